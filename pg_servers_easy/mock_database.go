@@ -7,6 +7,8 @@ import (
 	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type mockRow struct {
@@ -18,6 +20,7 @@ type mockDatabase interface {
 
 	// Вспомогательные методы для проверок в тестах
 	GetDataLen() int
+	GetParallel() int32
 	GetLoadСallNums() []int
 	GetSaveСallNums() []int
 }
@@ -29,11 +32,15 @@ type mockDB struct {
 	data  map[uint64]Row
 	maxID uint64
 
-	maxIDErr     bool  // будем ли имитировать кастомную ошибку в методе GetMaxID
-	loadRowsErr  bool  // будем ли имитировать временную ошибку в методе LoadRows
-	saveRowsErr  bool  // будем ли имитировать временную ошибку в методе SaveRows
-	loadСallNums []int // вызовы LoadRows() и кол-во отданных Rows
-	saveСallNums []int // вызовы SaveRows() и кол-во сохраненных Rows
+	maxIDErr     bool          // будем ли имитировать кастомную ошибку в методе GetMaxID
+	loadRowsErr  bool          // будем ли имитировать временную ошибку в методе LoadRows
+	saveRowsErr  bool          // будем ли имитировать временную ошибку в методе SaveRows
+	saveDelay    time.Duration // имитация медленной записи в SaveRows
+	loadСallNums []int         // вызовы LoadRows() и кол-во отданных Rows
+	saveСallNums []int         // вызовы SaveRows() и кол-во сохраненных Rows
+
+	current int32
+	max     int32
 }
 
 // Глобальное хранилище "подключений"
@@ -65,6 +72,34 @@ func NewMockDatabase(dbname string, ids []uint64, raiseMaxIDErr, raiseLoadRowsEr
 	return db
 }
 
+// SetSaveDelay задаёт задержку каждого вызова SaveRows, имитируя медленную запись
+func (db *mockDB) SetSaveDelay(d time.Duration) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveDelay = d
+
+	return db
+}
+
+// Reset очищает данные и накопленную статистику вызовов, чтобы базу можно было
+// переиспользовать между тест-кейсами без протекания состояния
+func (db *mockDB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data = map[uint64]Row{}
+	db.maxID = 0
+	db.loadСallNums = nil
+	db.saveСallNums = nil
+	atomic.StoreInt32(&db.max, 0)
+}
+
+// ResetMockDatabases очищает глобальное хранилище "подключений"
+func ResetMockDatabases() {
+	clear(mockDatabases)
+}
+
 type mockConnections struct {
 	Prod  mockDatabase
 	Stats mockDatabase
@@ -92,6 +127,7 @@ func getMockDatabases() (*mockConnections, error) {
 }
 
 // --- Реализация интерфейса Database ---
+
 func (db *mockDB) Close() error {
 	// Ничего не делаем
 	return nil
@@ -107,7 +143,19 @@ func (db *mockDB) GetMaxID(ctx context.Context) (uint64, error) {
 	return db.maxID, nil
 }
 
+// Ping нужен только для интерфейса Database: CopyTable этой задачи preflight не делает
+func (db *mockDB) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// ctxCheckEvery - как часто LoadRows проверяет отмену контекста при сканировании широкого диапазона
+const ctxCheckEvery = 100_000
+
 func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -119,6 +167,9 @@ func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, err
 	rows := []Row{}
 
 	for id := minID; id < maxID; id++ {
+		if (id-minID)%ctxCheckEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if r, ok := db.data[id]; ok {
 			rows = append(rows, r)
 		}
@@ -137,14 +188,50 @@ func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, err
 }
 
 func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	raiseErr := db.saveRowsErr
+	db.saveRowsErr = false // убираем ошибку после предполагаемого ретрая для последующих вызовов
+	delay := db.saveDelay
+	db.mu.Unlock()
 
-	if db.saveRowsErr {
-		db.saveRowsErr = false // убираем ошибку после предполагаемого ретрая для последующих вызовов
+	if raiseErr {
 		return ErrDBTemporal
 	}
 
+	// подсчитываем максимум SaveRows в моменте для теста многопоточки
+	// через атомики и CAS-loop, иначе если навешать на всё тело ф-ии Lock/defer Unlock,
+	// то словим Lock-contention и, несмотря на распараллеливание в решении, тут в моке
+	// сведется практически к поочередному выполнению
+	cur := atomic.AddInt32(&db.current, 1)
+	defer atomic.AddInt32(&db.current, -1)
+
+	// обновляем максимум
+	for {
+		max := atomic.LoadInt32(&db.max)
+		if cur > max && atomic.CompareAndSwapInt32(&db.max, max, cur) {
+			break
+		}
+		if cur <= max {
+			break
+		}
+	}
+
+	// задержка внутри учёта current, чтобы одновременные медленные записи были видны в max
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	for _, r := range rows {
 		if len(r) < 1 {
 			return fmt.Errorf("invalid row: %v", r)
@@ -154,6 +241,7 @@ func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
 			return fmt.Errorf("first column must be uint64, got %T", r[0])
 		}
 		id := mockRow.id
+
 		db.data[id] = r
 		if id > db.maxID {
 			db.maxID = id
@@ -184,6 +272,10 @@ func (db *mockDB) GetSaveСallNums() []int {
 	return db.saveСallNums
 }
 
+func (db *mockDB) GetParallel() int32 {
+	return atomic.LoadInt32(&db.max)
+}
+
 // Connect возвращает подключение к "базе"
 func Connect(ctx context.Context, dbname string) (mockDatabase, error) {
 	if db, ok := mockDatabases[dbname]; ok {
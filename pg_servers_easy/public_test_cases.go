@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var errGetMaxID = errors.New("error get max ID")
@@ -216,6 +217,30 @@ var testCases = []TestCase{
 			return len(dbs.Prod.GetLoadСallNums()) > 1 && len(dbs.Stats.GetSaveСallNums()) > 1
 		},
 	},
+	{
+		name: "Ожидается последовательное сохранение данных без параллелизма",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 100_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			CopyTable("PROD", "STATS", full)
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			return dbs.Stats.GetParallel() == 1 && len(dbs.Stats.GetSaveСallNums()) > 1
+		},
+	},
 	{
 		name: "Ожидается повторный вызов LoadRows() при возникновении краткосрочной ошибки",
 		full: true,
@@ -286,4 +311,62 @@ var testCases = []TestCase{
 			return prodMaxID == statsMaxID && dbs.Prod.GetDataLen() == dbs.Stats.GetDataLen()
 		},
 	},
+	{
+		name: "Ожидается отсутствие протекания статистики между прогонами после Reset",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 4, 5}, false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+			// прогоняет переливку в "подтесте" на той же базе и возвращает кол-во вызовов SaveRows
+			run := func() int {
+				if err := CopyTable("PROD", "STATS", full); err != nil {
+					return -1
+				}
+				return len(stats.GetSaveСallNums())
+			}
+
+			first := run()
+			stats.Reset()
+			if stats.GetDataLen() != 0 {
+				return false
+			}
+			second := run()
+
+			ResetMockDatabases()
+			_, err := Connect(context.Background(), "STATS")
+
+			return first == 1 && second == 1 && err != nil
+		},
+	},
+	{
+		name: "Ожидается параллельное сохранение без потери данных с WithWorkers",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 100_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveDelay(20 * time.Millisecond)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithWorkers(4)); err != nil {
+				return false
+			}
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			parallel := dbs.Stats.GetParallel()
+			return parallel > 1 && parallel <= 4 && dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
 }
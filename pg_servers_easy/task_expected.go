@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"golang.org/x/sync/errgroup"
 )
 
 type Row []interface{}
@@ -20,6 +22,9 @@ type Database interface {
 
 	// Сохраняет строки, вызов идемпотентен
 	SaveRows(ctx context.Context, rows []Row) error
+
+	// Проверяет доступность базы (подключение и права доступа)
+	Ping(ctx context.Context) error
 }
 
 // Также внутри пакета дана функция подключения:
@@ -29,9 +34,37 @@ type Database interface {
 // временные ошибки обернуты кастомной ошибкой ErrDBTemporal
 var ErrDBTemporal = errors.New("temporary db error")
 
+// CopyOption настраивает поведение CopyTable
+type CopyOption func(*copyConfig)
+
+type copyConfig struct {
+	// кол-во воркеров, переливающих батчи, 1 - последовательно
+	workers int
+}
+
+func newCopyConfig(opts []CopyOption) *copyConfig {
+	cfg := &copyConfig{workers: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithWorkers включает параллельную переливку: n воркеров разбирают батчи и
+// каждый сам загружает и сохраняет свой батч. По умолчанию переливка последовательная.
+// Порядок сохранения батчей при n > 1 не гарантируется.
+func WithWorkers(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.workers = max(n, 1)
+	}
+}
+
 // CopyTable копирует таблицу profiles с одного сервера на другой.
-func CopyTable(fromName string, toName string, full bool) error {
-	ctx := context.Background()
+func CopyTable(fromName string, toName string, full bool, opts ...CopyOption) error {
+	cfg := newCopyConfig(opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// retry для подключения к PROD
 	prodDB, err := withRetry(func() (Database, error) {
@@ -67,9 +100,8 @@ func CopyTable(fromName string, toName string, full bool) error {
 	}
 
 	batchSize := uint64(10_000)
-	batches := splitOnBatches(startID, endID, batchSize)
 
-	for interval := range batches {
+	copyBatch := func(ctx context.Context, interval [2]uint64) error {
 		rows, err := withRetry(func() ([]Row, error) {
 			return prodDB.LoadRows(ctx, interval[0], interval[1]+1)
 		})
@@ -83,22 +115,53 @@ func CopyTable(fromName string, toName string, full bool) error {
 		if err != nil {
 			return fmt.Errorf("cant save rows to db: %w", err)
 		}
+		return nil
+	}
+
+	if cfg.workers == 1 {
+		for interval := range splitOnBatches(ctx, startID, endID, batchSize) {
+			if err := copyBatch(ctx, interval); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 
-	return nil
+	// параллельный режим: те же батчи разбирает пул воркеров,
+	// ошибка одного отменяет контекст, и генератор батчей с остальными воркерами завершаются
+	g, gctx := errgroup.WithContext(ctx)
+	batches := splitOnBatches(gctx, startID, endID, batchSize)
+
+	for range cfg.workers {
+		g.Go(func() error {
+			for interval := range batches {
+				if err := copyBatch(gctx, interval); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return g.Wait()
 }
 
-// splitOnBatches возвращает канал батчей [start, end]
-func splitOnBatches(start, end, batchSize uint64) <-chan [2]uint64 {
+// splitOnBatches возвращает канал батчей [start, end], генерация прекращается при отмене ctx
+func splitOnBatches(ctx context.Context, start, end, batchSize uint64) <-chan [2]uint64 {
 	ch := make(chan [2]uint64)
 
 	go func() {
 		defer close(ch)
 		for i := start; i <= end; i += batchSize {
+			batch := [2]uint64{i, i + batchSize - 1}
 			if i+batchSize > end {
-				ch <- [2]uint64{i, end}
-			} else {
-				ch <- [2]uint64{i, i + batchSize - 1}
+				batch[1] = end
+			}
+
+			select {
+			case ch <- batch:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
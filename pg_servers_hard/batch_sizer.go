@@ -0,0 +1,101 @@
+package main
+
+import "reflect"
+
+// batchSizer подбирает кол-во строк в батче так, чтобы батч занимал примерно targetBytes.
+// Средний размер строки считаем скользящим средним по последним загрузкам, чтобы размер
+// батча подстраивался под данные, но не "прыгал" от одной выборки к другой
+// (иначе пострадает равномерность загрузки воркеров).
+type batchSizer struct {
+	targetBytes int
+	minRows     int
+	maxRows     int
+	rowSize     func(Row) int
+
+	avgRowBytes float64
+}
+
+func newBatchSizer(cfg *copyConfig) *batchSizer {
+	return &batchSizer{
+		targetBytes: cfg.targetBatchBytes,
+		minRows:     cfg.minBatchRows,
+		maxRows:     cfg.maxBatchRows,
+		rowSize:     cfg.rowSize,
+	}
+}
+
+// observe учитывает размер только что загруженных строк
+func (s *batchSizer) observe(rows []Row) {
+	if len(rows) == 0 {
+		return
+	}
+
+	total := 0
+	for _, r := range rows {
+		total += s.rowSize(r)
+	}
+	cur := float64(total) / float64(len(rows))
+
+	if s.avgRowBytes == 0 {
+		s.avgRowBytes = cur
+		return
+	}
+	s.avgRowBytes = (s.avgRowBytes + cur) / 2
+}
+
+// limit возвращает текущее кол-во строк в батче
+func (s *batchSizer) limit() int {
+	if s.avgRowBytes == 0 {
+		return s.maxRows
+	}
+
+	rows := int(float64(s.targetBytes) / s.avgRowBytes)
+	return min(max(rows, s.minRows), s.maxRows)
+}
+
+// estimateRowSize грубо оценивает объём строки в байтах, обходя значения колонок через reflect
+func estimateRowSize(r Row) int {
+	size := 0
+	for _, col := range r {
+		size += estimateValueSize(reflect.ValueOf(col))
+	}
+	return size
+}
+
+func estimateValueSize(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0
+	case reflect.String:
+		return v.Len()
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Len()
+		}
+		size := 0
+		for i := range v.Len() {
+			size += estimateValueSize(v.Index(i))
+		}
+		return size
+	case reflect.Map:
+		size := 0
+		iter := v.MapRange()
+		for iter.Next() {
+			size += estimateValueSize(iter.Key()) + estimateValueSize(iter.Value())
+		}
+		return size
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return estimateValueSize(v.Elem())
+	case reflect.Struct:
+		size := 0
+		for i := range v.NumField() {
+			size += estimateValueSize(v.Field(i))
+		}
+		return size
+	default:
+		return int(v.Type().Size())
+	}
+}
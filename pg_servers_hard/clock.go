@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// clock - источник времени для отложенного старта, подменяется в тестах
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
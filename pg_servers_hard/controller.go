@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// CopyController - хэндл запущенной в фоне переливки.
+// Позволяет приостановить её (например, на время регламентных работ) без потери позиции
+// и продолжить позже в рамках того же процесса, подключения при этом остаются открытыми.
+type CopyController struct {
+	gate *pauseGate
+	done chan struct{}
+	err  error
+
+	// статистика переливки, обновляется воркерами
+	rowsCopied     atomic.Uint64
+	batchesWritten atomic.Uint64
+	retries        atomic.Uint64
+	retryMetrics   retryMetrics
+	reservedRows   atomic.Uint64 // строки, зарезервированные под квоту WithMaxRows
+	endID          atomic.Uint64
+	expectedRows   atomic.Uint64                   // подсказка WithExpectedRows, 0 - не задана
+	watermark      atomic.Pointer[commitWatermark] // граница сохранённых id для ResumeToken
+
+	// запрос на досрочную отправку недобранного батча, см. Drain
+	drainCh chan struct{}
+
+	// прогресс для подписчика ProgressChan: буфер на одно значение, устаревшее вытесняется
+	progressMu sync.Mutex
+	progress   chan CopyProgress
+}
+
+// CopyProgress - состояние переливки после очередного сохранённого батча
+type CopyProgress struct {
+	RowsCopied     uint64
+	EndID          uint64 // максимальный id в PROD на момент старта переливки
+	BatchesWritten uint64
+	Percent        float64 // RowsCopied от WithExpectedRows в процентах, 0 - подсказка не задана
+}
+
+// CopyResult - итог (или промежуточное состояние) переливки
+type CopyResult struct {
+	RowsCopied     uint64 // кол-во сохранённых в STATS строк
+	BatchesWritten uint64 // кол-во успешных SaveRows
+	Retries        uint64 // кол-во повторов операций с базами
+	RetryMetrics   RetryMetrics
+
+	// токен для продолжения с места остановки (WithResumeToken),
+	// пуст, если переливка не дошла до расчёта диапазона
+	ResumeToken ResumeToken
+}
+
+// StartCopy запускает CopyTable в отдельной горутине и возвращает хэндл для управления ею
+func StartCopy(fromName string, toName string, full bool, opts ...CopyOption) *CopyController {
+	c := newCopyController()
+
+	go func() {
+		defer close(c.done)
+		c.err = c.run(fromName, toName, full, newCopyConfig(opts))
+	}()
+
+	return c
+}
+
+func newCopyController() *CopyController {
+	return &CopyController{
+		gate:     newPauseGate(),
+		done:     make(chan struct{}),
+		progress: make(chan CopyProgress, 1),
+		drainCh:  make(chan struct{}, 1),
+	}
+}
+
+// Pause останавливает выдачу новых LoadRows и разбор новых батчей воркерами.
+// Операции, которые уже в работе, доводятся до конца.
+func (c *CopyController) Pause() {
+	c.gate.pause()
+}
+
+// Resume продолжает переливку с места остановки
+func (c *CopyController) Resume() {
+	c.gate.resume()
+}
+
+// Wait дожидается завершения переливки и возвращает её результат
+func (c *CopyController) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// Result возвращает статистику переливки: после Wait - итоговую, до - текущую
+func (c *CopyController) Result() CopyResult {
+	res := CopyResult{
+		RowsCopied:     c.rowsCopied.Load(),
+		BatchesWritten: c.batchesWritten.Load(),
+		Retries:        c.retries.Load(),
+		RetryMetrics:   c.retryMetrics.snapshot(),
+	}
+	if w := c.watermark.Load(); w != nil {
+		res.ResumeToken = w.token()
+	}
+	return res
+}
+
+// Drain просит сборщик отправить воркерам недобранный батч сразу после текущей загрузки,
+// не дожидаясь его заполнения. Повторные вызовы до срабатывания схлопываются в один.
+// Вызов, когда батч не набирается (до старта или между батчами), отбрасывается на границе
+// следующего батча и его не делит.
+func (c *CopyController) Drain() {
+	select {
+	case c.drainCh <- struct{}{}:
+	default:
+	}
+}
+
+// ProgressChan возвращает канал с прогрессом переливки, например для live-дашборда.
+// Если читатель не успевает, промежуточные значения схлопываются до последнего,
+// так что переливка из-за медленного читателя не тормозит. Канал закрывается по завершении.
+func (c *CopyController) ProgressChan() <-chan CopyProgress {
+	return c.progress
+}
+
+// publishProgress отправляет актуальный прогресс, вытесняя непрочитанное значение
+func (c *CopyController) publishProgress() {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+
+	p := CopyProgress{
+		RowsCopied:     c.rowsCopied.Load(),
+		EndID:          c.endID.Load(),
+		BatchesWritten: c.batchesWritten.Load(),
+	}
+	if expected := c.expectedRows.Load(); expected > 0 {
+		p.Percent = float64(p.RowsCopied) / float64(expected) * 100
+	}
+
+	select {
+	case <-c.progress:
+	default:
+	}
+	c.progress <- p
+}
+
+func (c *CopyController) closeProgress() {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	close(c.progress)
+}
+
+// pauseGate - "шлагбаум", на котором стадии переливки ждут перед тем, как взять новую работу.
+// Открытое состояние - закрытый канал (чтение из него не блокируется),
+// на паузе канал подменяется новым, незакрытым.
+type pauseGate struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newPauseGate() *pauseGate {
+	ch := make(chan struct{})
+	close(ch)
+	return &pauseGate{ch: ch}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.ch:
+		g.ch = make(chan struct{})
+	default:
+		// уже на паузе
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.ch:
+		// и так открыт
+	default:
+		close(g.ch)
+	}
+}
+
+// wait блокируется, пока шлагбаум закрыт, либо до отмены контекста
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	ch := g.ch
+	g.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
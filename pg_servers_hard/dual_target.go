@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CopyTableDual переливает PROD сразу в две таблицы STATS, например старую и новую на время миграции.
+// Каждый батч пишется в обе параллельно и считается сохранённым, только если записан в обе
+// (с учётом ретраев). Продолжение (full=false) идёт от меньшего из max ID целей,
+// так что отставшая цель догоняется, а повторная запись в опередившую перезаписывает те же строки.
+// Служебные чтения (GetMeta, WithReadAfterWrite) выполняются по toA.
+func CopyTableDual(fromName string, toA string, toB string, full bool, opts ...CopyOption) error {
+	cfg := newCopyConfig(opts)
+	cfg.mirrorTo = toB
+	return newCopyController().run(fromName, toA, full, cfg)
+}
+
+// targets - имена всех таблиц STATS, в которые идёт переливка
+func (cfg *copyConfig) targets(toName string) []string {
+	if cfg.mirrorTo == "" {
+		return []string{toName}
+	}
+	return []string{toName, cfg.mirrorTo}
+}
+
+// connectTarget подключается к STATS, а в режиме CopyTableDual - к обеим целям
+func connectTarget(ctx context.Context, toName string, cfg *copyConfig) (Database, error) {
+	a, err := Connect(ctx, toName)
+	if err != nil || cfg.mirrorTo == "" {
+		return a, err
+	}
+
+	b, err := Connect(ctx, cfg.mirrorTo)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("connect to %s: %w", cfg.mirrorTo, err)
+	}
+	return &dualDatabase{a: a, b: b}, nil
+}
+
+// dualDatabase - две цели STATS под одним Database: запись в обе, чтение из a
+type dualDatabase struct {
+	a, b Database
+}
+
+func (d *dualDatabase) GetMaxID(ctx context.Context) (uint64, error) {
+	idA, err := d.a.GetMaxID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	idB, err := d.b.GetMaxID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return min(idA, idB), nil
+}
+
+func (d *dualDatabase) GetMinID(ctx context.Context) (uint64, error) {
+	return d.a.GetMinID(ctx)
+}
+
+func (d *dualDatabase) LoadRows(ctx context.Context, fromID, toID uint64) ([]Row, error) {
+	return d.a.LoadRows(ctx, fromID, toID)
+}
+
+func (d *dualDatabase) LoadRowsByIDs(ctx context.Context, ids []uint64) ([]Row, error) {
+	return d.a.LoadRowsByIDs(ctx, ids)
+}
+
+func (d *dualDatabase) SaveRows(ctx context.Context, rows []Row) error {
+	return d.both(ctx, func(ctx context.Context, db Database) error {
+		return db.SaveRows(ctx, rows)
+	})
+}
+
+func (d *dualDatabase) Ping(ctx context.Context) error {
+	return d.both(ctx, func(ctx context.Context, db Database) error {
+		return db.Ping(ctx)
+	})
+}
+
+func (d *dualDatabase) GetMeta(ctx context.Context, key string) (string, error) {
+	return d.a.GetMeta(ctx, key)
+}
+
+func (d *dualDatabase) SetMeta(ctx context.Context, key, value string) error {
+	return d.both(ctx, func(ctx context.Context, db Database) error {
+		return db.SetMeta(ctx, key, value)
+	})
+}
+
+func (d *dualDatabase) Close() error {
+	errA := d.a.Close()
+	errB := d.b.Close()
+	if errA != nil {
+		return errA
+	}
+	return errB
+}
+
+// both выполняет операцию над обеими целями параллельно
+func (d *dualDatabase) both(ctx context.Context, op func(context.Context, Database) error) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error { return op(gctx, d.a) })
+	g.Go(func() error { return op(gctx, d.b) })
+	return g.Wait()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// ErrDuplicateID - PROD отдал строку с уже встречавшимся id (WithDetectDuplicates)
+var ErrDuplicateID = errors.New("duplicate row id in source")
+
+// exactIDSetLimit - максимальная ширина диапазона id, для которой битсет заводится сразу целиком
+// (1<<27 id - 16 МиБ), на более широких диапазонах блоки битсета выделяются по мере заполнения
+const exactIDSetLimit = 1 << 27
+
+// sparseChunkIDs, sparseChunkLimit - кол-во id в блоке разреженного битсета (8 КиБ на блок)
+// и максимальное кол-во блоков (16 МиБ)
+const (
+	sparseChunkIDs   = 1 << 16
+	sparseChunkLimit = 2048
+)
+
+// bloomBits, bloomHashes - размер фильтра Блума (16 МиБ) и кол-во хэш-функций:
+// при 10М строк вне блоков вероятность ложного срабатывания на строку около 0.5%
+const (
+	bloomBits   = 1 << 27
+	bloomHashes = 4
+)
+
+// idSet - множество встреченных id, add возвращает true, если id уже встречался
+type idSet interface {
+	add(id uint64) bool
+}
+
+// newIDSet выбирает сплошной или разреженный битсет под диапазон [minID, maxID].
+// Сплошной всегда точный, разреженный - пока id укладываются в sparseChunkLimit блоков.
+func newIDSet(minID, maxID uint64) idSet {
+	if maxID-minID < exactIDSetLimit {
+		return newBitsetIDSet(minID, maxID)
+	}
+	return newSparseIDSet()
+}
+
+// bitsetIDSet - точное множество: бит на каждый id диапазона,
+// id вне диапазона (тоже признак бага источника) учитываются в map
+type bitsetIDSet struct {
+	minID    uint64
+	words    []uint64
+	outliers map[uint64]struct{}
+}
+
+func newBitsetIDSet(minID, maxID uint64) *bitsetIDSet {
+	return &bitsetIDSet{
+		minID:    minID,
+		words:    make([]uint64, (maxID-minID)/64+1),
+		outliers: map[uint64]struct{}{},
+	}
+}
+
+func (s *bitsetIDSet) add(id uint64) bool {
+	off := id - s.minID
+	if id < s.minID || off/64 >= uint64(len(s.words)) {
+		if _, ok := s.outliers[id]; ok {
+			return true
+		}
+		s.outliers[id] = struct{}{}
+		return false
+	}
+
+	word, mask := off/64, uint64(1)<<(off%64)
+	if s.words[word]&mask != 0 {
+		return true
+	}
+	s.words[word] |= mask
+	return false
+}
+
+// sparseIDSet - множество для широких диапазонов с ограниченной памятью: битсет разбит на блоки,
+// блок выделяется при первом id из него, пока их не больше sparseChunkLimit. Id из блоков,
+// не поместившихся в лимит, учитываются фильтром Блума фиксированного размера
+type sparseIDSet struct {
+	chunks map[uint64]*[sparseChunkIDs / 64]uint64
+	bloom  *bloomIDSet
+}
+
+func newSparseIDSet() *sparseIDSet {
+	return &sparseIDSet{chunks: map[uint64]*[sparseChunkIDs / 64]uint64{}}
+}
+
+func (s *sparseIDSet) add(id uint64) bool {
+	chunk, ok := s.chunks[id/sparseChunkIDs]
+	if !ok {
+		if len(s.chunks) >= sparseChunkLimit {
+			if s.bloom == nil {
+				s.bloom = newBloomIDSet()
+			}
+			return s.bloom.add(id)
+		}
+		chunk = new([sparseChunkIDs / 64]uint64)
+		s.chunks[id/sparseChunkIDs] = chunk
+	}
+
+	off := id % sparseChunkIDs
+	word, mask := off/64, uint64(1)<<(off%64)
+	if chunk[word]&mask != 0 {
+		return true
+	}
+	chunk[word] |= mask
+	return false
+}
+
+// bloomIDSet - фильтр Блума: дубликаты находит всегда,
+// но изредка может принять новый id за встречавшийся
+type bloomIDSet struct {
+	words []uint64
+}
+
+func newBloomIDSet() *bloomIDSet {
+	return &bloomIDSet{words: make([]uint64, bloomBits/64)}
+}
+
+func (s *bloomIDSet) add(id uint64) bool {
+	// двойное хэширование: i-я хэш-функция - h1 + i*h2
+	h1 := mix64(id)
+	h2 := mix64(h1) | 1
+
+	seen := true
+	for i := range uint64(bloomHashes) {
+		bit := (h1 + i*h2) % bloomBits
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if s.words[word]&mask == 0 {
+			seen = false
+			s.words[word] |= mask
+		}
+	}
+	return seen
+}
+
+// mix64 - финализатор splitmix64, перемешивает биты последовательных id
+func mix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ bits.RotateLeft64(x, -31)
+}
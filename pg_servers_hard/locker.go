@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAlreadyRunning - переливка в ту же таблицу STATS уже выполняется (WithLocker)
+var ErrAlreadyRunning = errors.New("copy to target is already running")
+
+// Locker - блокировка, не дающая двум переливкам писать в одну таблицу одновременно:
+// пересекающиеся переливки путают друг другу позицию возобновления по max ID в STATS.
+// Acquire не ждёт освобождения: если блокировка занята, возвращается ErrAlreadyRunning.
+// Для нескольких процессов можно подставить распределённую реализацию (например, advisory lock в базе).
+type Locker interface {
+	Acquire(ctx context.Context, key string) (release func(), err error)
+}
+
+// LocalLocker - реализация Locker в рамках одного процесса
+type LocalLocker struct {
+	mu   sync.Mutex
+	held map[string]struct{}
+}
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{held: map[string]struct{}{}}
+}
+
+func (l *LocalLocker) Acquire(ctx context.Context, key string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.held[key]; ok {
+		return nil, ErrAlreadyRunning
+	}
+	l.held[key] = struct{}{}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			delete(l.held, key)
+		})
+	}, nil
+}
+
+// lockKey - ключ блокировки переливки: целевая база и таблица
+func lockKey(toName string) string {
+	return toName + "/" + tableName
+}
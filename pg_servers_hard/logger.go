@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Logger - минимальный интерфейс логгера, в который CopyTable пишет ход переливки (см. WithLogger)
+type Logger interface {
+	Log(msg string) error
+}
+
+// logf пишет строку в логгер, если он задан. Ошибки логгера переливку не прерывают.
+func (cfg *copyConfig) logf(format string, args ...any) {
+	if cfg.logger == nil {
+		return
+	}
+	_ = cfg.logger.Log(fmt.Sprintf(format, args...))
+}
+
+// logSummary пишет итоговую строку переливки
+func (c *CopyController) logSummary(cfg *copyConfig, full bool, dur time.Duration, err error) {
+	res := c.Result()
+	status := "finished"
+	if err != nil {
+		status = "failed"
+	}
+
+	cfg.logf("copy %s: mode=%s rows=%d batches=%d retries=%d duration=%s err=%v",
+		status, copyMode(full), res.RowsCopied, res.BatchesWritten, res.Retries, dur.Round(time.Millisecond), err)
+}
+
+func copyMode(full bool) string {
+	if full {
+		return "full"
+	}
+	return "incremental"
+}
+
+// reportStats каждые WithStatsInterval пишет промежуточную статистику, пока не закрыт stop.
+// Скорость считается по строкам, сохранённым с прошлого отчёта.
+func (c *CopyController) reportStats(cfg *copyConfig, w *commitWatermark, stop <-chan struct{}) {
+	last := cfg.clock.Now()
+	lastRows := c.rowsCopied.Load()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-cfg.clock.After(cfg.statsInterval):
+			rows := c.rowsCopied.Load()
+			var rate float64
+			if elapsed := now.Sub(last); elapsed > 0 {
+				rate = float64(rows-lastRows) / elapsed.Seconds()
+			}
+
+			_ = cfg.statsLogger.Log(fmt.Sprintf("copy stats: rows=%d rate=%.1f rows/s retries=%d next_id=%d",
+				rows, rate, c.retries.Load(), w.nextID()))
+			last, lastRows = now, rows
+		}
+	}
+}
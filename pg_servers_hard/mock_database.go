@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"maps"
+	"runtime/pprof"
 	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,14 +19,36 @@ type mockRow struct {
 	id uint64
 }
 
+func (r mockRow) ID() uint64 {
+	return r.id
+}
+
+// GobEncode/GobDecode нужны для EncodeRows/DecodeRows, т.к. у mockRow нет экспортируемых полей
+func (r mockRow) GobEncode() ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, r.id), nil
+}
+
+func (r *mockRow) GobDecode(data []byte) error {
+	if len(data) != 8 {
+		return fmt.Errorf("invalid mockRow encoding: %d bytes", len(data))
+	}
+	r.id = binary.BigEndian.Uint64(data)
+	return nil
+}
+
 type mockDatabase interface {
 	Database
 
 	// Вспомогательные методы для проверок в тестах
 	GetDataLen() int
 	GetParallel() int32
+	GetOpenConns() int32
+	GetLoadParallel() int32
 	GetLoadСallNums() []int
 	GetSaveСallNums() []int
+	GetLoadByIDsCalls() [][]uint64
+	GetCallLabels() []string
+	GetSaveFirstIDs() []uint64
 }
 
 // mockDB имитирует базу данных (в памяти)
@@ -32,15 +58,40 @@ type mockDB struct {
 	data  map[uint64]Row
 	maxID uint64
 
-	maxIDErr     bool  // будем ли имитировать кастомную ошибку в методе GetMaxID
-	loadRowsErr  bool  // будем ли имитировать временную ошибку в методе LoadRows
-	saveRowsErr  bool  // будем ли имитировать временную ошибку в методе SaveRows
-	loadСallNums []int // вызовы LoadRows() и кол-во отданных Rows
-	saveСallNums []int // вызовы SaveRows() и кол-во сохраненных Rows
+	maxIDErr     bool            // будем ли имитировать кастомную ошибку в методе GetMaxID
+	loadRowsErr  bool            // будем ли имитировать временную ошибку в методе LoadRows
+	saveRowsErr  bool            // будем ли имитировать временную ошибку в методе SaveRows
+	pingErr      error           // ошибка, которую вернёт Ping
+	saveFlaky    bool            // будем ли имитировать временную ошибку на каждом втором вызове SaveRows
+	saveCalls    int             // кол-во вызовов SaveRows, включая неуспешные
+	connectErrs  int             // сколько ближайших вызовов Connect завершатся временной ошибкой
+	connectDelay time.Duration   // имитация медленного подключения в Connect
+	openConns    atomic.Int32    // кол-во подключений, открытых Connect и ещё не закрытых
+	connResets   int             // сколько ближайших LoadRows сломают своё подключение (только SetSerialConnections)
+	serialConns  bool            // будет ли Connect отдавать отдельные подключения, выполняющие запросы по очереди
+	saveDelay    time.Duration   // имитация медленной записи в SaveRows
+	saveGate     <-chan struct{} // если задан, каждый SaveRows ждёт из него значение перед записью
+	stallPeriod  time.Duration   // период и длительность остановок записи во всей базе, см. SetSaveStalls
+	stallFor     time.Duration
+	stallSince   time.Time
+	loadDelay    time.Duration     // имитация медленного чтения в LoadRows
+	loadGate     <-chan struct{}   // если задан, каждый LoadRows ждёт из него значение перед чтением
+	meta         map[string]string // служебные ключи SetMeta/GetMeta
+	saveHangs    int               // сколько ближайших вызовов SaveRows зависнут до отмены контекста
+	saveErrFor   map[uint64]error  // постоянные ошибки SaveRows для батчей с заданными id
+	dropWrites   map[uint64]bool   // id строк, которые SaveRows молча не сохранит
+	loadСallNums []int             // вызовы LoadRows() и кол-во отданных Rows
+	saveСallNums []int             // вызовы SaveRows() и кол-во сохраненных Rows
+	saveFirstIDs []uint64          // id первой строки каждого успешного SaveRows() в порядке вызовов
+
+	loadByIDsCalls [][]uint64          // вызовы LoadRowsByIDs() и запрошенные id
+	callLabels     map[string]struct{} // pprof-метки контекстов вызовов LoadRows/SaveRows
 
 	concurrencyCheck chan struct{}
 	current          int32
 	max              int32
+	loadCurrent      int32 // текущее и максимальное кол-во одновременных LoadRows
+	loadMax          int32
 	once             sync.Once
 }
 
@@ -74,6 +125,261 @@ func NewMockDatabase(dbname string, ids []uint64, raiseMaxIDErr, raiseLoadRowsEr
 	return db
 }
 
+// SetRowPayload добавляет к каждой строке колонку заданного размера в байтах, имитируя "широкие" строки
+func (db *mockDB) SetRowPayload(size int) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for id := range db.data {
+		db.data[id] = []interface{}{mockRow{id: id}, make([]byte, size)}
+	}
+
+	return db
+}
+
+// SetRowColumns добавляет к каждой строке после id колонки с заданными значениями
+func (db *mockDB) SetRowColumns(values ...any) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for id := range db.data {
+		db.data[id] = append([]interface{}{mockRow{id: id}}, values...)
+	}
+
+	return db
+}
+
+// AddDuplicateRow кладёт под id atID копию строки dupOf, имитируя баг источника,
+// из-за которого LoadRows отдаёт одну и ту же строку дважды
+func (db *mockDB) AddDuplicateRow(atID, dupOf uint64) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data[atID] = db.data[dupOf]
+	db.maxID = max(db.maxID, atID)
+
+	return db
+}
+
+// SetPingErr задаёт ошибку, которую будет возвращать Ping
+func (db *mockDB) SetPingErr(err error) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.pingErr = err
+
+	return db
+}
+
+// SetSaveRowsFlaky включает временную ошибку на каждом втором вызове SaveRows (начиная с первого)
+func (db *mockDB) SetSaveRowsFlaky(flaky bool) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveFlaky = flaky
+
+	return db
+}
+
+// SetConnectErrs задаёт кол-во ближайших вызовов Connect, которые вернут временную ошибку
+func (db *mockDB) SetConnectErrs(n int) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.connectErrs = n
+
+	return db
+}
+
+// Reset очищает данные и накопленную статистику вызовов, чтобы базу можно было
+// переиспользовать между тест-кейсами без протекания состояния
+func (db *mockDB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data = map[uint64]Row{}
+	db.meta = nil
+	db.maxID = 0
+	db.loadСallNums = nil
+	db.saveСallNums = nil
+	db.saveFirstIDs = nil
+	db.loadByIDsCalls = nil
+	db.saveCalls = 0
+	db.callLabels = nil
+	atomic.StoreInt32(&db.max, 0)
+	atomic.StoreInt32(&db.loadMax, 0)
+}
+
+// ResetMockDatabases очищает глобальное хранилище "подключений"
+func ResetMockDatabases() {
+	clear(mockDatabases)
+}
+
+// SetSerialConnections включает выдачу отдельного подключения на каждый Connect.
+// Данные у подключений общие, но в рамках одного подключения запросы выполняются по очереди,
+// как в настоящем соединении с PostgreSQL.
+func (db *mockDB) SetSerialConnections(serial bool) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.serialConns = serial
+
+	return db
+}
+
+// errConnReset - временная ошибка, после которой подключение непригодно, см. SetConnResets
+var errConnReset = fmt.Errorf("connection reset by peer: %w", ErrDBTemporal)
+
+// SetConnResets заставляет n ближайших вызовов LoadRows вернуть errConnReset и сломать подключение:
+// все дальнейшие LoadRows и SaveRows на нём тоже вернут errConnReset, новое подключение исправно.
+// Работает только с отдельными подключениями (SetSerialConnections).
+func (db *mockDB) SetConnResets(n int) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.connResets = n
+
+	return db
+}
+
+// mockConn - отдельное подключение к общей mockDB, см. SetSerialConnections
+type mockConn struct {
+	*mockDB
+	connMu sync.Mutex
+	broken bool // подключение сломано errConnReset
+}
+
+func (c *mockConn) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.broken {
+		return nil, errConnReset
+	}
+
+	c.mu.Lock()
+	reset := c.connResets > 0
+	if reset {
+		c.connResets--
+	}
+	c.mu.Unlock()
+
+	if reset {
+		c.broken = true
+		return nil, errConnReset
+	}
+	return c.mockDB.LoadRows(ctx, minID, maxID)
+}
+
+func (c *mockConn) SaveRows(ctx context.Context, rows []Row) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.broken {
+		return errConnReset
+	}
+	return c.mockDB.SaveRows(ctx, rows)
+}
+
+// SetSaveDelay задаёт задержку каждого вызова SaveRows, имитируя медленную запись
+func (db *mockDB) SetSaveDelay(d time.Duration) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveDelay = d
+
+	return db
+}
+
+// SetSaveGate заставляет каждый SaveRows дождаться значения из gate (или закрытия gate),
+// так что тест сам решает, когда воркеры освободятся
+func (db *mockDB) SetSaveGate(gate <-chan struct{}) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveGate = gate
+
+	return db
+}
+
+// SetSaveStalls имитирует периодические остановки записи во всей базе (например, checkpoint):
+// SaveRows, попавший в первые stall каждого периода period, ждёт окончания остановки
+func (db *mockDB) SetSaveStalls(period, stall time.Duration) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.stallPeriod, db.stallFor, db.stallSince = period, stall, time.Now()
+
+	return db
+}
+
+// SetDropWrites заставляет SaveRows молча терять строки с перечисленными id, сообщая об успехе
+func (db *mockDB) SetDropWrites(ids ...uint64) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.dropWrites = make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		db.dropWrites[id] = true
+	}
+
+	return db
+}
+
+// SetConnectDelay задаёт задержку каждого вызова Connect, имитируя медленное подключение
+func (db *mockDB) SetConnectDelay(d time.Duration) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.connectDelay = d
+
+	return db
+}
+
+// SetSaveHangs заставляет n ближайших вызовов SaveRows зависнуть до отмены их контекста
+func (db *mockDB) SetSaveHangs(n int) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.saveHangs = n
+
+	return db
+}
+
+// SetSaveErrFor заставляет SaveRows возвращать err для любого батча, содержащего строку id
+func (db *mockDB) SetSaveErrFor(id uint64, err error) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.saveErrFor == nil {
+		db.saveErrFor = map[uint64]error{}
+	}
+	db.saveErrFor[id] = err
+
+	return db
+}
+
+// SetLoadGate заставляет каждый LoadRows дождаться значения из gate (или закрытия gate),
+// так что тест сам решает, когда очередная загрузка завершится
+func (db *mockDB) SetLoadGate(gate <-chan struct{}) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.loadGate = gate
+
+	return db
+}
+
+// SetLoadDelay задаёт задержку каждого вызова LoadRows, имитируя медленное чтение
+func (db *mockDB) SetLoadDelay(d time.Duration) *mockDB {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.loadDelay = d
+
+	return db
+}
+
 type mockConnections struct {
 	Prod  mockDatabase
 	Stats mockDatabase
@@ -103,7 +409,7 @@ func getMockDatabases() (*mockConnections, error) {
 // --- Реализация интерфейса Database ---
 
 func (db *mockDB) Close() error {
-	// Ничего не делаем
+	db.openConns.Add(-1)
 	return nil
 }
 
@@ -117,7 +423,82 @@ func (db *mockDB) GetMaxID(ctx context.Context) (uint64, error) {
 	return db.maxID, nil
 }
 
+func (db *mockDB) GetMinID(ctx context.Context) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var minID uint64
+	found := false
+	for id := range db.data {
+		if !found || id < minID {
+			minID, found = id, true
+		}
+	}
+	return minID, nil
+}
+
+func (db *mockDB) GetMeta(ctx context.Context, key string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.meta[key], nil
+}
+
+func (db *mockDB) SetMeta(ctx context.Context, key, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.meta == nil {
+		db.meta = map[string]string{}
+	}
+	db.meta[key] = value
+	return nil
+}
+
+func (db *mockDB) Ping(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.pingErr
+}
+
+// ctxCheckEvery - как часто LoadRows проверяет отмену контекста при сканировании широкого диапазона
+const ctxCheckEvery = 100_000
+
 func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// задержка выполняется вне mu, поэтому одновременные LoadRows видны именно в ней
+	cur := atomic.AddInt32(&db.loadCurrent, 1)
+	defer atomic.AddInt32(&db.loadCurrent, -1)
+	for {
+		max := atomic.LoadInt32(&db.loadMax)
+		if cur <= max || atomic.CompareAndSwapInt32(&db.loadMax, max, cur) {
+			break
+		}
+	}
+
+	db.mu.Lock()
+	delay := db.loadDelay
+	gate := db.loadGate
+	db.mu.Unlock()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -129,12 +510,16 @@ func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, err
 	rows := []Row{}
 
 	for id := minID; id < maxID; id++ {
+		if (id-minID)%ctxCheckEvery == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if r, ok := db.data[id]; ok {
 			rows = append(rows, r)
 		}
 	}
 
 	db.loadСallNums = append(db.loadСallNums, len(rows))
+	db.recordLabels(ctx, "LoadRows")
 
 	// обеспечиванием последовательное возрастание ID
 	sort.SliceStable(rows, func(i, j int) bool {
@@ -146,12 +531,90 @@ func (db *mockDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, err
 	return rows, nil
 }
 
+func (db *mockDB) LoadRowsByIDs(ctx context.Context, ids []uint64) ([]Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.loadByIDsCalls = append(db.loadByIDsCalls, slices.Clone(ids))
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+
+	rows := []Row{}
+	for _, id := range slices.Compact(sorted) {
+		if r, ok := db.data[id]; ok {
+			rows = append(rows, r)
+		}
+	}
+
+	return rows, nil
+}
+
 func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
-	if db.saveRowsErr {
-		db.mu.Lock()
-		db.saveRowsErr = false // убираем ошибку после предполагаемого ретрая для последующих вызовов
-		db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	delay := db.saveDelay
+	gate := db.saveGate
+	if db.stallPeriod > 0 {
+		if pos := time.Since(db.stallSince) % db.stallPeriod; pos < db.stallFor {
+			delay += db.stallFor - pos
+		}
+	}
+	hang := db.saveHangs > 0
+	if hang {
+		db.saveHangs--
+	}
+	raiseErr := db.saveRowsErr
+	db.saveRowsErr = false // убираем ошибку после предполагаемого ретрая для последующих вызовов
+	db.mu.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if raiseErr {
+		return ErrDBTemporal
+	}
+
+	db.mu.Lock()
+	for _, r := range rows {
+		if len(r) > 0 {
+			if id, ok := r[0].(mockRow); ok && db.saveErrFor[id.id] != nil {
+				err := db.saveErrFor[id.id]
+				db.mu.Unlock()
+				return err
+			}
+		}
+	}
+	flakyErr := db.saveFlaky && db.saveCalls%2 == 0
+	db.saveCalls++
+	db.mu.Unlock()
+
+	if flakyErr {
 		return ErrDBTemporal
 	}
 
@@ -160,6 +623,7 @@ func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
 	// то словим Lock-contention и, несмотря на распараллеливание в решении, тут в моке
 	// сведется практически к поочередному выполнению
 	cur := atomic.AddInt32(&db.current, 1)
+	defer atomic.AddInt32(&db.current, -1)
 
 	// обновляем максимум
 	for {
@@ -186,6 +650,8 @@ func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
 	}
 
 	db.mu.Lock()
+	defer db.mu.Unlock()
+
 	for _, r := range rows {
 		if len(r) < 1 {
 			return fmt.Errorf("invalid row: %v", r)
@@ -195,6 +661,9 @@ func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
 			return fmt.Errorf("first column must be uint64, got %T", r[0])
 		}
 		id := mockRow.id
+		if db.dropWrites[id] {
+			continue
+		}
 
 		db.data[id] = r
 		if id > db.maxID {
@@ -203,9 +672,10 @@ func (db *mockDB) SaveRows(ctx context.Context, rows []Row) error {
 	}
 
 	db.saveСallNums = append(db.saveСallNums, len(rows))
-	db.mu.Unlock()
-
-	atomic.AddInt32(&db.current, -1)
+	if len(rows) > 0 {
+		db.saveFirstIDs = append(db.saveFirstIDs, rows[0][0].(mockRow).id)
+	}
+	db.recordLabels(ctx, "SaveRows")
 
 	return nil
 }
@@ -229,15 +699,153 @@ func (db *mockDB) GetSaveСallNums() []int {
 	return db.saveСallNums
 }
 
+func (db *mockDB) GetSaveFirstIDs() []uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.saveFirstIDs
+}
+
+func (db *mockDB) GetLoadByIDsCalls() [][]uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.loadByIDsCalls
+}
+
+// recordLabels запоминает pprof-метки контекста вызова в виде "method: k=v,k=v", вызывается под mu
+func (db *mockDB) recordLabels(ctx context.Context, method string) {
+	var labels []string
+	pprof.ForLabels(ctx, func(key, value string) bool {
+		labels = append(labels, key+"="+value)
+		return true
+	})
+	slices.Sort(labels)
+
+	if db.callLabels == nil {
+		db.callLabels = map[string]struct{}{}
+	}
+	db.callLabels[method+": "+strings.Join(labels, ",")] = struct{}{}
+}
+
+// GetCallLabels возвращает отсортированный список различных pprof-меток вызовов LoadRows/SaveRows
+func (db *mockDB) GetCallLabels() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return slices.Sorted(maps.Keys(db.callLabels))
+}
+
 func (db *mockDB) GetParallel() int32 {
 	return atomic.LoadInt32(&db.max)
 }
 
+func (db *mockDB) GetOpenConns() int32 {
+	return db.openConns.Load()
+}
+
+func (db *mockDB) GetLoadParallel() int32 {
+	return atomic.LoadInt32(&db.loadMax)
+}
+
 // Connect возвращает подключение к "базе"
 func Connect(ctx context.Context, dbname string) (mockDatabase, error) {
 	if db, ok := mockDatabases[dbname]; ok {
+		db.mu.Lock()
+		delay := db.connectDelay
+		db.mu.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if db.connectErrs > 0 {
+			db.connectErrs--
+			return nil, fmt.Errorf("connect to %s: %w", dbname, ErrDBTemporal)
+		}
+
+		db.openConns.Add(1)
+		if db.serialConns {
+			return &mockConn{mockDB: db}, nil
+		}
 		return db, nil
 	}
 
 	return nil, errors.New("no database found")
 }
+
+// mockLogger собирает строки лога в памяти для проверок в тестах
+type mockLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *mockLogger) Log(msg string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, msg)
+	return nil
+}
+
+func (l *mockLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.lines)
+}
+
+// mockClock - управляемые вручную часы для тестов отложенного старта
+type mockClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []mockClockWaiter
+}
+
+type mockClockWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newMockClock(now time.Time) *mockClock {
+	return &mockClock{now: now}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *mockClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, mockClockWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance переводит часы вперёд и срабатывает наступившие ожидания
+func (c *mockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.waiters = slices.DeleteFunc(c.waiters, func(w mockClockWaiter) bool {
+		if w.at.After(c.now) {
+			return false
+		}
+		w.ch <- c.now
+		return true
+	})
+}
+
+// Waiters возвращает кол-во ещё не сработавших ожиданий
+func (c *mockClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
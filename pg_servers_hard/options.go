@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// CopyOption настраивает поведение CopyTable/StartCopy.
+// По умолчанию (без опций) поведение совпадает с исходным: батчи по batchSize строк.
+type CopyOption func(*copyConfig)
+
+type copyConfig struct {
+	// родительский контекст переливки, его отмена прерывает переливку
+	ctx context.Context
+
+	// блокировка от параллельных переливок в одну таблицу, nil - без блокировки
+	locker Locker
+
+	// идентификатор источника для защиты STATS от смешивания данных, "" - без проверки
+	expectedSourceID string
+
+	// проверка доступности баз перед началом переливки
+	preflight bool
+
+	// лимит сохранённых строк, 0 - без лимита
+	maxRows uint64
+
+	// общий лимит повторов на всю переливку, 0 - без лимита
+	retryBudget int
+
+	// логгер хода переливки, nil - не логируем
+	logger Logger
+
+	// дополнительный классификатор временных ошибок, nil - только ErrDBTemporal
+	retryable func(error) bool
+
+	// сохранение токена возобновления при ошибке переливки, nil - не сохраняем
+	checkpointOnError func(ResumeToken) error
+
+	// колбэк завершения переливки, nil - не вызываем
+	onComplete func(CopyResult, error)
+
+	// стратегия джиттера пауз между повторами, nil - по умолчанию (JitterFull)
+	jitter     *JitterStrategy
+	jitterRand *lockedRand
+
+	// глубина предзагрузки батчей из PROD, 0 - без предзагрузки
+	readahead int
+
+	// лимит батчей в работе от загрузки до сохранения, 0 - без лимита
+	maxInFlightBatches int
+
+	// сторожевой таймаут одного SaveRows, 0 - без таймаута
+	saveTimeout time.Duration
+
+	// максимальное время набора батча, 0 - набираем до заполнения
+	batchFlushTimeout time.Duration
+
+	// кол-во параллельных LoadRows на диапазон одного батча, 1 - последовательно
+	loadConcurrency int
+
+	// лимит одновременных LoadRows, 0 - без лимита
+	maxOpenRanges int
+
+	// кол-во подключений к STATS, между которыми распределяются воркеры
+	statsConnections int
+
+	// отдельная политика повторов для подключения, 0 - общая политика
+	connectAttempts int
+	connectBackoff  time.Duration
+
+	// отчёт о пропусках id больше порога, nil - не отслеживаем
+	gapThreshold uint64
+	gapReporter  func(fromID, toID uint64)
+
+	// периодическая статистика в отдельный логгер, 0 - выключена
+	statsInterval time.Duration
+	statsLogger   Logger
+
+	// ошибки, после которых подключение непригодно и его нужно переоткрыть, nil - не переоткрываем
+	isConnFatal func(error) bool
+
+	// сверка STATS с токеном возобновления, nil - без сверки
+	resumeVerify *ResumeVerifyPolicy
+
+	// принудительная стартовая позиция для full=false, nil - вычисляется
+	forceStartID *uint64
+
+	// токен возобновления от прошлого запуска, "" - возобновляем по max ID в STATS
+	resumeToken ResumeToken
+
+	// отложенный старт переливки, нулевые значения - стартуем сразу
+	startAt    time.Time
+	startDelay time.Duration
+	clock      clock
+
+	// срок, к которому переливка прекращает брать новые батчи, нулевой - без срока
+	deadline       time.Time
+	deadlineMargin time.Duration
+
+	// пустой PROD при полной переливке считается ошибкой
+	requireNonEmpty bool
+
+	// вторая цель STATS для CopyTableDual, пусто - одна цель
+	mirrorTo string
+
+	// ожидаемое кол-во строк к переливке, 0 - неизвестно
+	expectedRows uint64
+
+	// сохранение строго по возрастанию id
+	sortedSave bool
+
+	// колонки, которые сохраняются в STATS помимо id, nil - все колонки
+	keepColumns []int
+
+	// проверка каждого сохранённого батча чтением из STATS
+	readAfterWrite bool
+
+	// эпохи STATS для отсева устаревших токенов возобновления
+	replayProtection bool
+
+	// проверка уникальности id строк из PROD
+	detectDuplicates bool
+
+	// контрольная сумма батча между загрузкой и сохранением
+	batchChecksum bool
+
+	// преобразование батча перед сохранением, nil - без преобразования (используется в тестах)
+	transformBatch func([]Row)
+
+	// адаптивный размер батча, включается WithTargetBatchBytes
+	targetBatchBytes int
+	minBatchRows     int
+	maxBatchRows     int
+	rowSize          func(Row) int
+}
+
+func newCopyConfig(opts []CopyOption) *copyConfig {
+	cfg := &copyConfig{
+		ctx:              context.Background(),
+		minBatchRows:     1,
+		statsConnections: 1,
+		loadConcurrency:  1,
+		maxBatchRows:     batchSize,
+		rowSize:          estimateRowSize,
+		clock:            realClock{},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithContext задаёт родительский контекст: его отмена или дедлайн прерывают переливку
+func WithContext(ctx context.Context) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.ctx = ctx
+	}
+}
+
+// WithPreflight включает проверку доступности обеих баз (Ping) сразу после подключения,
+// чтобы не узнавать о проблемах с доступом уже после расчёта диапазонов id
+func WithPreflight() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.preflight = true
+	}
+}
+
+// WithMaxRows ограничивает кол-во строк, которое может перенести одна переливка.
+// При достижении лимита переливка останавливается с ErrQuotaExceeded,
+// сколько успели перенести - см. CopyController.Result.
+func WithMaxRows(n uint64) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.maxRows = n
+	}
+}
+
+// WithRetryBudget ограничивает суммарное кол-во повторов по всем операциям переливки.
+// Когда бюджет исчерпан, переливка завершается с ErrRetryBudgetExhausted
+// вместо того, чтобы продолжать повторять каждый батч по отдельности.
+func WithRetryBudget(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.retryBudget = n
+	}
+}
+
+// WithLogger включает логирование этапов переливки и итоговой строки
+// (кол-во строк, батчей, повторов, длительность, режим)
+func WithLogger(l Logger) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.logger = l
+	}
+}
+
+// WithConnectRetry задаёт отдельную политику повторов для подключения к базам:
+// attempts - общее кол-во попыток, backoff - базовая пауза между ними.
+// Позволяет пережить перезапуск базы на старте, не делая столь же терпеливыми повторы батчей.
+func WithConnectRetry(attempts int, backoff time.Duration) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.connectAttempts = attempts
+		cfg.connectBackoff = backoff
+	}
+}
+
+// WithRetryJitter задаёт стратегию джиттера пауз между повторами.
+// rnd позволяет подменить источник случайности (например, с фиксированным seed в тестах),
+// nil - глобальный источник math/rand.
+func WithRetryJitter(strategy JitterStrategy, rnd *rand.Rand) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.jitter = &strategy
+		cfg.jitterRand = nil
+		if rnd != nil {
+			cfg.jitterRand = &lockedRand{rnd: rnd}
+		}
+	}
+}
+
+// WithConnections задаёт кол-во подключений к STATS (по умолчанию одно на всех воркеров).
+// Воркеры распределяются между подключениями по кругу, больше чем workers открывать смысла нет.
+func WithConnections(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.statsConnections = min(max(n, 1), workers)
+	}
+}
+
+// WithReadahead разрешает сборщику заранее загрузить из PROD до n батчей, пока воркеры
+// заняты медленным сохранением, чтобы чтение и запись шли внахлёст.
+// Память: одновременно в работе может находиться до workers + n + 1 батчей
+// (по одному у каждого воркера, n в очереди и один набираемый сборщиком).
+func WithReadahead(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.readahead = max(n, 0)
+	}
+}
+
+// WithTargetBatchBytes включает подбор кол-ва строк в батче под целевой объём в байтах.
+// Объём строк оценивается по недавно загруженным данным (см. WithRowSizer),
+// итоговое кол-во строк ограничивается WithBatchRowBounds.
+func WithTargetBatchBytes(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.targetBatchBytes = n
+	}
+}
+
+// WithBatchRowBounds задаёт границы кол-ва строк в батче для адаптивного режима
+func WithBatchRowBounds(minRows, maxRows int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.minBatchRows = max(minRows, 1)
+		cfg.maxBatchRows = max(maxRows, cfg.minBatchRows)
+	}
+}
+
+// WithRowSizer подменяет оценку размера строки в байтах (по умолчанию - обход через reflect)
+func WithRowSizer(fn func(Row) int) CopyOption {
+	return func(cfg *copyConfig) {
+		if fn != nil {
+			cfg.rowSize = fn
+		}
+	}
+}
+
+// WithBatchChecksum включает проверку целостности батчей: сборщик считает CRC32 над
+// сериализованным батчем (см. EncodeRows), воркер пересчитывает её перед SaveRows и
+// при расхождении прерывает переливку с ErrBatchCorrupted.
+// Пользовательские типы колонок должны быть зарегистрированы через RegisterRowType.
+func WithBatchChecksum() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.batchChecksum = true
+	}
+}
+
+// withBatchTransform подменяет батч между загрузкой и сохранением
+func withBatchTransform(fn func([]Row)) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.transformBatch = fn
+	}
+}
+
+// WithGapReporter включает отчёт о крупных пропусках id в PROD (например, после удалений):
+// fn вызывается из сборщика для каждого диапазона отсутствующих id [fromID, toID]
+// между соседними загруженными строками, если в нём больше threshold id.
+// Id строки берётся из первой колонки (см. RowIDer).
+func WithGapReporter(threshold uint64, fn func(fromID, toID uint64)) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.gapThreshold = threshold
+		cfg.gapReporter = fn
+	}
+}
+
+// WithResumeToken продолжает переливку (full=false) с позиции из токена прошлого запуска
+// (CopyResult.ResumeToken) вместо max ID в STATS. Если источник с тех пор несовместимо
+// изменился, переливка не начинается и возвращается ErrResumeMismatch. При full=true токен игнорируется.
+// Строки, которые прошлый запуск загрузил, но не успел сохранить, дочитываются по id (LoadRowsByIDs),
+// а уже сохранённые вне очереди батчи повторно не сканируются.
+func WithResumeToken(t ResumeToken) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.resumeToken = t
+	}
+}
+
+// WithSortedSave гарантирует, что SaveRows получают строки в порядке возрастания id
+// по всей переливке (полезно для append-only хранилищ).
+// Цена - запись без параллелизма: батчи сохраняются одним воркером по очереди,
+// поэтому при медленной записи переливка идёт примерно в workers раз дольше.
+// Загрузку из PROD внахлёст с записью можно сохранить через WithReadahead.
+func WithSortedSave() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.sortedSave = true
+	}
+}
+
+// WithRequireNonEmpty делает пустой PROD при полной переливке ошибкой ErrSourceEmpty,
+// чтобы отличать "источник пуст" от "ничего не сделано из-за ошибки".
+// По умолчанию такая переливка успешно завершается без работы.
+func WithRequireNonEmpty() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.requireNonEmpty = true
+	}
+}
+
+// WithStartAt откладывает чтение и запись данных до момента t: подключение и preflight
+// выполняются сразу, чтобы запланированные на одно время переливки не подключались разом.
+// Ожидание прерывается отменой контекста (WithContext).
+func WithStartAt(t time.Time) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.startAt = t
+		cfg.startDelay = 0
+	}
+}
+
+// WithStartDelay - то же, что WithStartAt, но время старта отсчитывается от запуска переливки
+func WithStartDelay(d time.Duration) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.startDelay = d
+		cfg.startAt = time.Time{}
+	}
+}
+
+// withClock подменяет источник времени (отложенный старт, сроки, периодическая статистика)
+func withClock(c clock) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.clock = c
+	}
+}
+
+// WithRetryable дополняет проверку errors.Is(err, ErrDBTemporal) классификатором
+// временных ошибок драйвера (дедлоки, обрывы соединения, таймауты), чтобы их не
+// приходилось оборачивать в ErrDBTemporal. Ошибки, подходящие под любое из условий, повторяются.
+func WithRetryable(fn func(error) bool) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.retryable = fn
+	}
+}
+
+// WithMaxInFlightBatches ограничивает кол-во батчей, загруженных из PROD, но ещё не сохранённых,
+// независимо от WithReadahead и кол-ва воркеров: сборщик не начинает загрузку нового батча,
+// пока их в работе n. Это жёсткий потолок памяти под батчи при медленной записи.
+func WithMaxInFlightBatches(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.maxInFlightBatches = n
+	}
+}
+
+// WithKeepColumns сохраняет в STATS только колонки с перечисленными индексами (в заданном порядке),
+// например, если в целевой таблице меньше колонок. Колонка id (0) сохраняется всегда первой.
+// Индекс за пределами строки прерывает переливку с ошибкой.
+func WithKeepColumns(indices []int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.keepColumns = slices.Clone(indices)
+		if cfg.keepColumns == nil {
+			cfg.keepColumns = []int{}
+		}
+	}
+}
+
+// WithBatchTimeoutFlush отправляет воркерам недобранный батч, если его набор длится дольше d.
+// На разреженных id один батч может требовать множества LoadRows по пустым диапазонам,
+// и без таймаута воркеры простаивают до его заполнения. Пока в батче нет ни одной строки,
+// сканирование продолжается. Проверка выполняется между вызовами LoadRows.
+func WithBatchTimeoutFlush(d time.Duration) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.batchFlushTimeout = d
+	}
+}
+
+// WithLocker запрещает параллельные переливки в одну таблицу STATS: перед стартом берётся
+// блокировка l по целевой базе и таблице, и если она занята, переливка сразу завершается
+// с ErrAlreadyRunning. В рамках процесса подойдёт NewLocalLocker.
+func WithLocker(l Locker) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.locker = l
+	}
+}
+
+// WithDeadline задаёт срок окна переливки: начиная с deadline-margin сборщик не берёт новые батчи,
+// воркеры дописывают уже взятые, и переливка завершается с ErrDeadlineReached.
+// margin - запас времени на дозапись батчей в работе. В отличие от отмены контекста,
+// батчи не обрываются посередине, а CopyResult содержит частичную статистику и ResumeToken.
+func WithDeadline(deadline time.Time, margin time.Duration) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.deadline = deadline
+		cfg.deadlineMargin = margin
+	}
+}
+
+// deadlineNear - пора ли прекращать брать новые батчи (WithDeadline)
+func (cfg *copyConfig) deadlineNear() bool {
+	return !cfg.deadline.IsZero() && !cfg.clock.Now().Before(cfg.deadline.Add(-cfg.deadlineMargin))
+}
+
+// WithReadAfterWrite после каждого SaveRows перечитывает сохранённые строки из STATS
+// (LoadRowsByIDs) и прерывает переливку с ErrWriteNotConfirmed, если какой-то не хватает.
+// Удваивает нагрузку на STATS, поэтому включается только для критичных переливок.
+func WithReadAfterWrite() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.readAfterWrite = true
+	}
+}
+
+// WithLoadConcurrency делит диапазон id каждой загрузки батча на n поддиапазонов и загружает
+// их параллельно, сохраняя порядок строк по id. Ускоряет чтение широких разреженных диапазонов,
+// если PROD выдерживает параллельные LoadRows. При повторе перезагружается только упавший поддиапазон.
+func WithLoadConcurrency(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.loadConcurrency = max(n, 1)
+	}
+}
+
+// WithOnComplete задаёт колбэк, который вызывается ровно один раз по завершении переливки,
+// успешном или нет (включая ошибки подключения и preflight), с итоговой статистикой и ошибкой.
+// Вызывается в горутине переливки до возврата из CopyTable (или до завершения Wait у StartCopy).
+func WithOnComplete(fn func(result CopyResult, err error)) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.onComplete = fn
+	}
+}
+
+// WithExpectedSourceID защищает STATS от смешивания данных разных источников: при первой
+// переливке id источника записывается в STATS (SetMeta), а последующие переливки с другим id
+// не начинаются и возвращают ErrSourceMismatch.
+func WithExpectedSourceID(id string) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.expectedSourceID = id
+	}
+}
+
+// WithMaxOpenRanges ограничивает кол-во одновременных LoadRows к PROD, например если каждый
+// вызов держит курсор или подключение, а их в базе немного. Лимит действует независимо
+// от WithLoadConcurrency и прочих источников параллельных загрузок.
+func WithMaxOpenRanges(n int) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.maxOpenRanges = n
+	}
+}
+
+// WithReplayProtection ведёт в STATS номер эпохи (SetMeta), который растёт с каждой полной
+// переливкой и попадает в ResumeToken. Продолжение по токену из предыдущей эпохи
+// (выданному до полной переливки) отклоняется с ErrStaleCheckpoint.
+func WithReplayProtection() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.replayProtection = true
+	}
+}
+
+// WithDetectDuplicates прерывает переливку с ErrDuplicateID, если PROD отдаёт строку с уже
+// встречавшимся id (в том же или другом батче): STATS молча перезаписала бы её.
+// Для диапазонов id до exactIDSetLimit проверка точная (битсет выделяется сразу), на более
+// широких битсет выделяется блоками по мере заполнения, но не больше 16 МиБ (sparseChunkLimit
+// блоков). Id из блоков сверх лимита проверяются фильтром Блума (ещё 16 МиБ), который изредка
+// может принять новый id за повтор, так что память ограничена ~32 МиБ при любом диапазоне.
+func WithDetectDuplicates() CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.detectDuplicates = true
+	}
+}
+
+// WithCheckpointOnError при завершении переливки с ошибкой передаёт в save токен возобновления
+// с последней позицией, до которой все батчи гарантированно сохранены: батчи, сохранённые
+// воркерами вне очереди после незавершённого, в неё не входят, так что продолжение
+// по токену не пропустит дыру. Ошибка save добавляется к ошибке переливки.
+func WithCheckpointOnError(save func(ResumeToken) error) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.checkpointOnError = save
+	}
+}
+
+// WithSaveTimeout включает сторожевой таймер на каждый вызов SaveRows: зависший дольше d вызов
+// отменяется (его контекст) и считается временной ошибкой, т.е. батч сохраняется повторно
+// по общей политике повторов, а воркер не блокируется навсегда.
+func WithSaveTimeout(d time.Duration) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.saveTimeout = d
+	}
+}
+
+// WithForceStartID задаёт стартовый id для продолжения (full=false) вместо max ID в STATS
+// или позиции из WithResumeToken - ручной выход для восстановления после порчи STATS.
+// id больше max ID в PROD считается ошибкой. При full=true игнорируется.
+func WithForceStartID(id uint64) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.forceStartID = &id
+	}
+}
+
+// WithExpectedRows задаёт примерное кол-во строк, которое предстоит перелить.
+// По нему считается процент в CopyProgress и подбирается размер буферов батчей,
+// а при заметном расхождении с фактом в логгер (WithLogger) пишется предупреждение.
+func WithExpectedRows(n uint64) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.expectedRows = n
+	}
+}
+
+// WithResumeVerification перед продолжением по WithResumeToken сверяет STATS с позицией токена.
+// Расхождение говорит о несогласованном прошлом запуске, policy определяет реакцию на него.
+func WithResumeVerification(policy ResumeVerifyPolicy) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.resumeVerify = &policy
+	}
+}
+
+// WithReconnectOnTemporalError переоткрывает подключение (с ретраями WithConnectRetry), если
+// временная ошибка ErrDBTemporal по isConnFatal оставила его непригодным (например, connection reset),
+// чтобы повтор операции шёл уже по новому подключению, а не бился в сломанное.
+func WithReconnectOnTemporalError(isConnFatal func(error) bool) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.isConnFatal = isConnFatal
+	}
+}
+
+// WithStatsInterval каждые d пишет в logger промежуточную статистику: всего строк, скорость
+// с прошлого отчёта, повторы и позицию, до которой всё сохранено. Итоговая строка WithLogger не меняется.
+func WithStatsInterval(d time.Duration, logger Logger) CopyOption {
+	return func(cfg *copyConfig) {
+		cfg.statsInterval = d
+		cfg.statsLogger = logger
+	}
+}
@@ -1,11 +1,64 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 )
 
 var errGetMaxID = errors.New("error get max ID")
+var errPing = errors.New("permission denied")
+var errDiskFull = errors.New("disk full")
+
+// benchmarkWithRetry - вызов withRetry, исчерпывающий retries повторов с минимальными паузами
+func benchmarkWithRetry(b *testing.B, retries int) {
+	policy := retryPolicy{maxRetries: retries, backoff: time.Microsecond, jitter: JitterNone}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for range b.N {
+		withRetry(ctx, policy, func() (struct{}, error) {
+			return struct{}{}, ErrDBTemporal
+		})
+	}
+}
+
+// benchmarkReadahead - переливка из PROD с медленным чтением в STATS, запись в которую
+// периодически останавливается
+func benchmarkReadahead(b *testing.B, opts ...CopyOption) {
+	const rowNum = 30 * batchSize
+	ids := make([]uint64, rowNum)
+	for i := range ids {
+		ids[i] = uint64(i + 1)
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		NewMockDatabase("PROD", ids, false, false, false).SetLoadDelay(5 * time.Millisecond)
+		NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveStalls(250*time.Millisecond, 200*time.Millisecond)
+		b.StartTimer()
+
+		if err := CopyTable("PROD", "STATS", true, opts...); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(rowNum*b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+// rowsPerSec - пропускная способность из отчёта benchmarkReadahead
+func rowsPerSec(r testing.BenchmarkResult) float64 {
+	return r.Extra["rows/s"]
+}
 
 type TestCase struct {
 	name string
@@ -361,4 +414,2070 @@ var testCases = []TestCase{
 			return prodMaxID == statsMaxID && dbs.Prod.GetDataLen() == dbs.Stats.GetDataLen()
 		},
 	},
+	{
+		name: "Ожидается приостановка и возобновление переливки через CopyController",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 1_000_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			ctl := StartCopy("PROD", "STATS", full)
+
+			// дожидаемся, пока переливка начнётся
+			deadline := time.Now().Add(concurrentTestTimeout)
+			for len(dbs.Stats.GetSaveСallNums()) == 0 {
+				if time.Now().After(deadline) {
+					return false
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			ctl.Pause()
+
+			// даём дописаться батчам, которые уже были в работе на момент паузы
+			time.Sleep(50 * time.Millisecond)
+			loadsOnPause := len(dbs.Prod.GetLoadСallNums())
+			savesOnPause := len(dbs.Stats.GetSaveСallNums())
+
+			// на паузе прогресса быть не должно
+			time.Sleep(100 * time.Millisecond)
+			if len(dbs.Prod.GetLoadСallNums()) != loadsOnPause || len(dbs.Stats.GetSaveСallNums()) != savesOnPause {
+				return false
+			}
+
+			ctl.Resume()
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+
+			return savesOnPause < len(dbs.Stats.GetSaveСallNums()) && dbs.Prod.GetDataLen() == dbs.Stats.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается отправка недобранного батча по Drain и отбрасывание устаревшего Drain",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// id через 10: батч набирается за много LoadRows, а не за один
+			prodIds := make([]uint64, 2*batchSize)
+			for i := range prodIds {
+				prodIds[i] = uint64(i+1) * 10
+			}
+
+			// Drain до начала переливки устарел к первому батчу и не делит его
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+			c := newCopyController()
+			c.Drain()
+			if err := c.run("PROD", "STATS", full, newCopyConfig(nil)); err != nil {
+				return false
+			}
+			if !slices.Equal(stats.GetSaveСallNums(), []int{batchSize, batchSize}) {
+				return false
+			}
+
+			// Drain во время набора первого батча: он уходит недобранным, остальное доливается
+			gate := make(chan struct{})
+			NewMockDatabase("PROD", prodIds, false, false, false).SetLoadGate(gate)
+			stats = NewMockDatabase("STATS", []uint64{}, false, false, false)
+			ctl := StartCopy("PROD", "STATS", full)
+			gate <- struct{}{}
+			ctl.Drain()
+			close(gate)
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+
+			sizes, firstIDs := stats.GetSaveСallNums(), stats.GetSaveFirstIDs()
+			first := slices.Index(firstIDs, prodIds[0])
+			return first >= 0 && sizes[first] < batchSize && stats.GetDataLen() == len(prodIds)
+		},
+	},
+	{
+		name: "Ожидается подбор кол-ва строк в батче под целевой объём (WithTargetBatchBytes)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const prodRowNum = 20_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// сохраняет данные с целевым объёмом батча ~80Кб и возвращает размеры батчей
+			copyWithTarget := func(payload int) []int {
+				NewMockDatabase("PROD", prodIds, false, false, false).SetRowPayload(payload)
+				NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+				err := CopyTable("PROD", "STATS", full, WithTargetBatchBytes(80_000), WithBatchRowBounds(10, batchSize))
+				if err != nil {
+					return nil
+				}
+
+				dbs, err := getMockDatabases()
+				if err != nil {
+					return nil
+				}
+				return dbs.Stats.GetSaveСallNums()
+			}
+
+			// подсчитывает батчи размером не более limit строк
+			countUpTo := func(nums []int, limit int) int {
+				cnt := 0
+				for _, n := range nums {
+					if n <= limit {
+						cnt++
+					}
+				}
+				return cnt
+			}
+
+			// узкие строки (~8 байт) упираются в верхнюю границу кол-ва строк
+			narrow := copyWithTarget(0)
+			if len(narrow) != prodRowNum/batchSize || countUpTo(narrow, batchSize-1) != 0 {
+				return false
+			}
+
+			// широкие строки (~1Кб) - около 80 строк в батче, кроме первого, на котором оценивается размер
+			wide := copyWithTarget(1_000)
+			return len(wide) > 100 && countUpTo(wide, 100) >= len(wide)-1
+		},
+	},
+	{
+		name: "Ожидается прерывание переливки до чтения данных, если база недоступна (WithPreflight)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetPingErr(errPing)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			err := CopyTable("PROD", "STATS", full, WithPreflight())
+			dbs, dbErr := getMockDatabases()
+			if dbErr != nil {
+				return false
+			}
+
+			return errors.Is(err, errPing) && len(dbs.Prod.GetLoadСallNums()) == 0
+		},
+	},
+	{
+		name: "Ожидается остановка переливки по достижении лимита строк (WithMaxRows)",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 100_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const maxRows = 35_000
+
+			ctl := StartCopy("PROD", "STATS", full, WithMaxRows(maxRows))
+			err := ctl.Wait()
+			res := ctl.Result()
+
+			dbs, dbErr := getMockDatabases()
+			if dbErr != nil {
+				return false
+			}
+
+			return errors.Is(err, ErrQuotaExceeded) &&
+				res.RowsCopied == maxRows &&
+				res.BatchesWritten == uint64(len(dbs.Stats.GetSaveСallNums())) &&
+				dbs.Stats.GetDataLen() == maxRows
+		},
+	},
+	{
+		name: "Ожидается ошибка при возобновлении, если PROD отстаёт от STATS",
+		full: false,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false)
+			NewMockDatabase("STATS", []uint64{1, 2, 3, 4, 5}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			err := CopyTable("PROD", "STATS", full)
+			dbs, dbErr := getMockDatabases()
+			if dbErr != nil {
+				return false
+			}
+
+			return errors.Is(err, ErrSourceRewound) && len(dbs.Prod.GetLoadСallNums()) == 0
+		},
+	},
+	{
+		name: "Ожидается прерывание переливки при исчерпании общего бюджета повторов (WithRetryBudget)",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 1_000_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveRowsFlaky(true)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// каждый второй SaveRows падает, поэтому на сотню батчей трёх повторов не хватит,
+			// хотя по отдельности каждый батч успешно сохранился бы с повтора
+			err := CopyTable("PROD", "STATS", full, WithRetryBudget(3))
+			dbs, dbErr := getMockDatabases()
+			if dbErr != nil {
+				return false
+			}
+
+			return errors.Is(err, ErrRetryBudgetExhausted) && dbs.Stats.GetDataLen() < dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается совпадение строк после сериализации EncodeRows/DecodeRows",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 5, 1_998_193}, false, false, false).SetRowPayload(16)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			RegisterRowType(mockRow{})
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			rows, err := dbs.Prod.LoadRows(context.Background(), 0, 2_000_000)
+			if err != nil {
+				return false
+			}
+
+			data, err := EncodeRows(rows)
+			if err != nil {
+				return false
+			}
+
+			decoded, err := DecodeRows(data)
+			if err != nil {
+				return false
+			}
+
+			return len(rows) == 4 && reflect.DeepEqual(rows, decoded)
+		},
+	},
+	{
+		name: "Ожидается итоговая строка лога с корректной статистикой переливки (WithLogger)",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 1_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, true, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			logger := &mockLogger{}
+			if err := CopyTable("PROD", "STATS", full, WithLogger(logger)); err != nil {
+				return false
+			}
+
+			lines := logger.Lines()
+			if len(lines) == 0 {
+				return false
+			}
+
+			return strings.HasPrefix(lines[0], "copy started: PROD -> STATS, mode=full") &&
+				strings.HasPrefix(lines[len(lines)-1], "copy finished: mode=full rows=1000 batches=1 retries=1 ")
+		},
+	},
+	{
+		name: "Ожидается отдельная политика повторов для подключения (WithConnectRetry)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false).SetConnectErrs(4)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// общая политика допускает лишь 3 повтора с паузами от 100мс,
+			// а с политикой подключения 5 попыток по 1мс переживаем 4 неудачных Connect
+			started := time.Now()
+			err := CopyTable("PROD", "STATS", full, WithConnectRetry(5, time.Millisecond))
+			elapsed := time.Since(started)
+
+			dbs, dbErr := getMockDatabases()
+			if dbErr != nil {
+				return false
+			}
+
+			return err == nil && elapsed < backoffBaseForRetries && dbs.Stats.GetDataLen() == 3
+		},
+	},
+	{
+		name: "Ожидается монотонно растущий прогресс в ProgressChan вплоть до полного объёма",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 1_000_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			ctl := StartCopy("PROD", "STATS", full)
+
+			var last CopyProgress
+			received := 0
+			for p := range ctl.ProgressChan() {
+				if p.RowsCopied <= last.RowsCopied || p.BatchesWritten <= last.BatchesWritten {
+					return false
+				}
+				last = p
+				received++
+			}
+
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+
+			return received > 1 && last.RowsCopied == 1_000_100 && last.EndID == 1_000_100
+		},
+	},
+	{
+		name: "Ожидается одно сохранение без лишних загрузок, если данных меньше одного батча",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 500
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			CopyTable("PROD", "STATS", full)
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			return slices.Equal(dbs.Prod.GetLoadСallNums(), []int{500}) && slices.Equal(dbs.Stats.GetSaveСallNums(), []int{500})
+		},
+	},
+	{
+		name: "Ожидается перенос последней строки, когда набранный диапазон упирается ровно в максимальный ID",
+		full: true,
+		prepare: func() struct{} {
+			// первые две загрузки [0, 10000) и [10000, 10001) заканчиваются ровно на максимальном ID
+			prodIds := make([]uint64, 0, batchSize)
+			for i := range batchSize - 1 {
+				prodIds = append(prodIds, uint64(i+1))
+			}
+			prodIds = append(prodIds, batchSize+1)
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			CopyTable("PROD", "STATS", full)
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			return dbs.Prod.GetDataLen() == dbs.Stats.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидаются детерминированные паузы между повторами для каждой стратегии джиттера",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// ожидаемые паузы для seed=42 и базы 100мс
+			expected := map[JitterStrategy][]time.Duration{
+				JitterNone:         {100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond},
+				JitterFull:         {131_278_675, 343_856_411, 501_878_760, 926_624_009},
+				JitterEqual:        {81_278_675, 143_856_411, 301_878_760, 526_624_009},
+				JitterDecorrelated: {131_278_675, 108_001_136, 163_155_880, 386_806_569},
+			}
+
+			for strategy, delays := range expected {
+				policy := retryPolicy{
+					backoff: 100 * time.Millisecond,
+					jitter:  strategy,
+					rand:    &lockedRand{rnd: rand.New(rand.NewSource(42))},
+				}
+
+				// так же, как паузы считаются в withRetry
+				backoff := policy.backoff
+				var sleep time.Duration
+				for _, want := range delays {
+					sleep = policy.nextDelay(backoff, sleep)
+					if sleep != want {
+						return false
+					}
+					backoff *= 2
+				}
+			}
+
+			return true
+		},
+	},
+	{
+		name: "Ожидается быстрый выход из withRetry с ошибкой контекста при его отмене во время паузы",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// паузы 5, 10, 20, 40мс... - к моменту отмены таймер успеет переиспользоваться
+			policy := retryPolicy{maxRetries: 10, backoff: 5 * time.Millisecond, jitter: JitterNone}
+			calls := 0
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			started := time.Now()
+			_, err := withRetry(ctx, policy, func() (struct{}, error) {
+				calls++
+				return struct{}{}, ErrDBTemporal
+			})
+
+			return errors.Is(err, context.Canceled) && calls > 2 && time.Since(started) < time.Second
+		},
+	},
+	{
+		name: "Ожидается одинаковое кол-во аллокаций withRetry при любом кол-ве повторов (бенчмарк переиспользования таймера)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// при таймере на каждую попытку аллокации росли бы с кол-вом повторов
+			few := testing.Benchmark(func(b *testing.B) { benchmarkWithRetry(b, 2) })
+			many := testing.Benchmark(func(b *testing.B) { benchmarkWithRetry(b, 12) })
+			return few.N > 0 && many.N > 0 && many.AllocsPerOp() == few.AllocsPerOp()
+		},
+	},
+	{
+		name: "Ожидается загрузка только запрошенных строк по id в порядке возрастания (LoadRowsByIDs)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 4, 7, 9, 12}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			// 3 и 10 в базе нет
+			ids := []uint64{9, 3, 1, 12, 10}
+			rows, err := dbs.Prod.LoadRowsByIDs(context.Background(), ids)
+			if err != nil {
+				return false
+			}
+
+			loaded := make([]uint64, 0, len(rows))
+			for _, r := range rows {
+				loaded = append(loaded, r[0].(mockRow).id)
+			}
+
+			calls := dbs.Prod.GetLoadByIDsCalls()
+			return slices.Equal(loaded, []uint64{1, 9, 12}) && len(calls) == 1 && slices.Equal(calls[0], ids)
+		},
+	},
+	{
+		name: "Ожидается дочитывание по id только несохранённых строк при возобновлении по токену (LoadRowsByIDs)",
+		full: false,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const prodRowNum = 100_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// переливка падает посреди диапазона: часть батчей после позиции токена уже сохранена
+			var token ResumeToken
+			save := func(t ResumeToken) error {
+				token = t
+				return nil
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveErrFor(45_000, errDiskFull)
+			if err := CopyTable("PROD", "STATS", true, WithCheckpointOnError(save)); !errors.Is(err, errDiskFull) {
+				return false
+			}
+			st, err := decodeResumeToken(token)
+			if err != nil || len(st.Missing) == 0 || st.LoadedTo <= st.NextID {
+				return false
+			}
+
+			saved, err := stats.LoadRows(context.Background(), 0, prodRowNum+1)
+			if err != nil {
+				return false
+			}
+			inStats := map[uint64]bool{}
+			for _, r := range saved {
+				inStats[r[0].(mockRow).id] = true
+			}
+
+			stats.SetSaveErrFor(45_000, nil)
+			prod := NewMockDatabase("PROD", prodIds, false, false, false)
+			if err := CopyTable("PROD", "STATS", full, WithResumeToken(token)); err != nil {
+				return false
+			}
+
+			// по id запрошены ровно недостающие в STATS строки до LoadedTo
+			var fetched []uint64
+			for _, call := range prod.GetLoadByIDsCalls() {
+				fetched = append(fetched, call...)
+			}
+			var missing []uint64
+			for id := st.NextID; id < st.LoadedTo; id++ {
+				if !inStats[id] {
+					missing = append(missing, id)
+				}
+			}
+			if !slices.Equal(fetched, missing) {
+				return false
+			}
+
+			// диапазон сканируется только с LoadedTo
+			scanned := 0
+			for _, n := range prod.GetLoadСallNums() {
+				scanned += n
+			}
+			return scanned == prodRowNum-int(st.LoadedTo)+1 && stats.GetDataLen() == prodRowNum
+		},
+	},
+	{
+		name: "Ожидаются проверки WithDetectDuplicates, WithGapReporter и WithDeadline для строк, дочитанных по токену",
+		full: false,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// прошлый запуск не сохранил 10 001..10 500, остальное до 20 000 уже в STATS
+			token := encodeResumeToken(resumeState{
+				Table: tableName, Source: "PROD", NextID: 10_001, SourceMinID: 1,
+				Missing: []idRun{{10_001, 10_500}}, LoadedTo: 20_001,
+			})
+			newStats := func() {
+				NewMockDatabase("STATS", append(slices.Clone(prodIds[:10_000]), prodIds[10_500:20_000]...), false, false, false)
+			}
+
+			// дочитанная по id строка, которую PROD отдаёт повторно в сканируемом диапазоне
+			NewMockDatabase("PROD", prodIds, false, false, false).AddDuplicateRow(25_000, 10_100)
+			newStats()
+			err := CopyTable("PROD", "STATS", full, WithResumeToken(token), WithDetectDuplicates())
+			if !errors.Is(err, ErrDuplicateID) || !strings.Contains(err.Error(), "row 10100") {
+				return false
+			}
+
+			// пропуск между дочитанными строками и сканированием
+			var gaps [][2]uint64
+			report := func(fromID, toID uint64) {
+				gaps = append(gaps, [2]uint64{fromID, toID})
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			newStats()
+			if err := CopyTable("PROD", "STATS", full, WithResumeToken(token), WithGapReporter(0, report)); err != nil ||
+				!slices.Equal(gaps, [][2]uint64{{10_501, 20_000}}) {
+				return false
+			}
+
+			// срок уже наступил: дочитывание по id не начинается
+			prod := NewMockDatabase("PROD", prodIds, false, false, false)
+			newStats()
+			err = CopyTable("PROD", "STATS", full, WithResumeToken(token), WithDeadline(time.Now(), 0))
+			return errors.Is(err, ErrDeadlineReached) && len(prod.GetLoadByIDsCalls()) == 0
+		},
+	},
+	{
+		name: "Ожидается отсутствие протекания статистики между прогонами после Reset",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 4, 5}, false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+			// прогоняет переливку в "подтесте" на той же базе и возвращает кол-во вызовов SaveRows
+			run := func() int {
+				if err := CopyTable("PROD", "STATS", full); err != nil {
+					return -1
+				}
+				return len(stats.GetSaveСallNums())
+			}
+
+			first := run()
+			stats.Reset()
+			if stats.GetDataLen() != 0 {
+				return false
+			}
+			second := run()
+
+			ResetMockDatabases()
+			_, err := Connect(context.Background(), "STATS")
+
+			return first == 1 && second == 1 && err != nil
+		},
+	},
+	{
+		name: "Ожидается рост параллелизма записи с размером пула подключений (WithConnections)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const prodRowNum = 200_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// возвращает максимальный параллелизм SaveRows для заданного размера пула
+			parallelWithPool := func(n int) int32 {
+				NewMockDatabase("PROD", prodIds, false, false, false)
+				stats := NewMockDatabase("STATS", []uint64{}, false, false, false).SetSerialConnections(true)
+				if err := CopyTable("PROD", "STATS", full, WithConnections(n)); err != nil {
+					return 0
+				}
+				if stats.GetDataLen() != prodRowNum {
+					return 0
+				}
+				return stats.GetParallel()
+			}
+
+			single := parallelWithPool(1)
+			pooled := parallelWithPool(4)
+
+			return single == 1 && pooled > 1 && pooled <= 4
+		},
+	},
+	{
+		name: "Ожидается ошибка отмены контекста из LoadRows/SaveRows и её проброс из CopyTable",
+		full: true,
+		prepare: func() struct{} {
+			const prodRowNum = 1_000_100
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			cancelled, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, loadErr := dbs.Prod.LoadRows(cancelled, 0, 10)
+			saveErr := dbs.Stats.SaveRows(cancelled, []Row{{mockRow{id: 1}}})
+			if !errors.Is(loadErr, context.Canceled) || !errors.Is(saveErr, context.Canceled) || dbs.Stats.GetDataLen() != 0 {
+				return false
+			}
+
+			// отменяем контекст посреди переливки
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ctl := StartCopy("PROD", "STATS", full, WithContext(ctx))
+			deadline := time.Now().Add(concurrentTestTimeout)
+			for len(dbs.Stats.GetSaveСallNums()) == 0 {
+				if time.Now().After(deadline) {
+					return false
+				}
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			return errors.Is(ctl.Wait(), context.Canceled) && dbs.Stats.GetDataLen() < dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается предзагрузка батчей из PROD во время медленной записи (WithReadahead)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const prodRowNum = 300_000
+			prodIds := make([]uint64, prodRowNum)
+			for i := range prodRowNum {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// проверяет, что пока запись стоит, сборщик загружает ровно want батчей и не больше
+			loadsWhileSaving := func(want int, opts ...CopyOption) bool {
+				gate := make(chan struct{})
+				prod := NewMockDatabase("PROD", prodIds, false, false, false)
+				stats := NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveGate(gate)
+
+				ctl := StartCopy("PROD", "STATS", full, opts...)
+				loadedBatches := func() int {
+					rows := 0
+					for _, n := range prod.GetLoadСallNums() {
+						rows += n
+					}
+					return rows / batchSize
+				}
+
+				// ждём want загруженных батчей, затем убеждаемся, что сборщик упёрся
+				// в занятых воркеров и буфер предзагрузки и больше не читает
+				for deadline := time.Now().Add(concurrentTestTimeout); loadedBatches() < want && time.Now().Before(deadline); {
+					time.Sleep(time.Millisecond)
+				}
+				time.Sleep(50 * time.Millisecond)
+				loaded, saved := loadedBatches(), len(stats.GetSaveСallNums())
+
+				close(gate)
+				return ctl.Wait() == nil && loaded == want && saved == 0 && stats.GetDataLen() == prodRowNum
+			}
+
+			// без предзагрузки: по батчу у каждого воркера и один у сборщика
+			if !loadsWhileSaving(workers+1) || !loadsWhileSaving(workers+5+1, WithReadahead(5)) {
+				return false
+			}
+
+			// бенчмарк: при периодических остановках записи предзагрузка успевает набрать батчи
+			// на время остановки, и после неё воркеры не ждут чтения из PROD
+			base := testing.Benchmark(func(b *testing.B) { benchmarkReadahead(b) })
+			ahead := testing.Benchmark(func(b *testing.B) { benchmarkReadahead(b, WithReadahead(workers)) })
+			return rowsPerSec(ahead) > 1.2*rowsPerSec(base)
+		},
+	},
+	{
+		name: "Ожидается обнаружение порчи батча между загрузкой и сохранением (WithBatchChecksum)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			RegisterRowType(mockRow{})
+
+			prodIds := make([]uint64, 25_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// без порчи контрольные суммы сходятся и переливка проходит целиком
+			NewMockDatabase("PROD", prodIds, false, false, false).SetRowPayload(8)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+			if err := CopyTable("PROD", "STATS", full, WithBatchChecksum()); err != nil || stats.GetDataLen() != len(prodIds) {
+				return false
+			}
+
+			// подменяем payload первой строки батча уже после подсчёта контрольной суммы
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			corrupt := func(rows []Row) {
+				rows[0] = Row{rows[0][0], []byte("corrupted")}
+			}
+			err := CopyTable("PROD", "STATS", full, WithBatchChecksum(), withBatchTransform(corrupt))
+
+			return errors.Is(err, ErrBatchCorrupted)
+		},
+	},
+	{
+		name: "Ожидается отчёт о пропуске id больше порога с точными границами (WithGapReporter)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 10, 11, 500_000, 500_001, 1_000_000}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			var mu sync.Mutex
+			var gaps [][2]uint64
+			report := func(fromID, toID uint64) {
+				mu.Lock()
+				defer mu.Unlock()
+				gaps = append(gaps, [2]uint64{fromID, toID})
+			}
+
+			if err := CopyTable("PROD", "STATS", full, WithGapReporter(100, report)); err != nil {
+				return false
+			}
+
+			// пропуск 4..9 меньше порога и не репортится
+			expected := [][2]uint64{{12, 499_999}, {500_002, 999_999}}
+			return reflect.DeepEqual(gaps, expected)
+		},
+	},
+	{
+		name: "Ожидается продолжение переливки с позиции из токена прошлого запуска (WithResumeToken)",
+		full: false,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			ids := func(n int) []uint64 {
+				res := make([]uint64, n)
+				for i := range res {
+					res[i] = uint64(i + 1)
+				}
+				return res
+			}
+
+			NewMockDatabase("PROD", ids(15_000), false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+			ctl := StartCopy("PROD", "STATS", true)
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+			token := ctl.Result().ResumeToken
+
+			// в PROD дописались строки, а STATS пустая: с токеном переливаются только новые строки,
+			// без него (по max ID в STATS) переливка пошла бы с начала
+			NewMockDatabase("PROD", ids(30_000), false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+			ctl = StartCopy("PROD", "STATS", full, WithResumeToken(token))
+			if err := ctl.Wait(); err != nil || stats.GetDataLen() != 15_000 {
+				return false
+			}
+
+			// токен второго запуска указывает за конец источника
+			st, err := decodeResumeToken(ctl.Result().ResumeToken)
+			return err == nil && st.NextID == 30_001
+		},
+	},
+	{
+		name: "Ожидается отказ в продолжении по токену при изменившемся источнике (ErrResumeMismatch)",
+		full: false,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 4, 5}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			ctl := StartCopy("PROD", "STATS", true)
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+			token := ctl.Result().ResumeToken
+
+			// PROD пересоздан: начало таблицы уже другое
+			NewMockDatabase("PROD", []uint64{3, 4, 5, 6}, false, false, false)
+			stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+			err := CopyTable("PROD", "STATS", full, WithResumeToken(token))
+			errGarbage := CopyTable("PROD", "STATS", full, WithResumeToken("garbage"))
+
+			return errors.Is(err, ErrResumeMismatch) && errors.Is(errGarbage, ErrResumeMismatch) && stats.GetDataLen() == 0
+		},
+	},
+	{
+		name: "Ожидаются pprof-метки стадий в контекстах LoadRows/SaveRows",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 100_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			if !slices.Equal(dbs.Prod.GetCallLabels(), []string{"LoadRows: role=collector"}) {
+				return false
+			}
+
+			saveLabels := dbs.Stats.GetCallLabels()
+			for _, l := range saveLabels {
+				id, ok := strings.CutPrefix(l, "SaveRows: id=")
+				if !ok || !strings.HasSuffix(id, ",role=save-worker") {
+					return false
+				}
+			}
+			return len(saveLabels) > 0
+		},
+	},
+	{
+		name: "Ожидается сохранение батчей по возрастанию id (WithSortedSave)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 0, 100_000)
+			for i := range 100_000 {
+				prodIds = append(prodIds, uint64(i*3+1))
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithSortedSave(), WithReadahead(2)); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			firstIDs := dbs.Stats.GetSaveFirstIDs()
+			return dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen() &&
+				len(firstIDs) > 1 && slices.IsSorted(firstIDs)
+		},
+	},
+	{
+		name: "Ожидается ErrSourceEmpty при полной переливке пустого PROD только с WithRequireNonEmpty",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full); err != nil {
+				return false
+			}
+			if err := CopyTable("PROD", "STATS", full, WithRequireNonEmpty()); !errors.Is(err, ErrSourceEmpty) {
+				return false
+			}
+
+			// непустой PROD с опцией переливается как обычно
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false)
+			return CopyTable("PROD", "STATS", full, WithRequireNonEmpty()) == nil
+		},
+	},
+	{
+		name: "Ожидается отсутствие LoadRows до назначенного времени старта и быстрый выход при отмене (WithStartAt)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			// ждёт, пока переливка не встанет на ожидание старта
+			waitScheduled := func(clk *mockClock) bool {
+				deadline := time.Now().Add(time.Second)
+				for clk.Waiters() == 0 {
+					if time.Now().After(deadline) {
+						return false
+					}
+					time.Sleep(time.Millisecond)
+				}
+				return true
+			}
+
+			t0 := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+			clk := newMockClock(t0)
+			ctl := StartCopy("PROD", "STATS", full, WithStartAt(t0.Add(time.Hour)), withClock(clk))
+			if !waitScheduled(clk) {
+				return false
+			}
+
+			clk.Advance(59 * time.Minute)
+			time.Sleep(20 * time.Millisecond)
+			if len(dbs.Prod.GetLoadСallNums()) != 0 {
+				return false
+			}
+
+			clk.Advance(time.Minute)
+			if err := ctl.Wait(); err != nil || dbs.Stats.GetDataLen() != 3 {
+				return false
+			}
+
+			// отмена во время ожидания
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			clk = newMockClock(t0)
+			ctl = StartCopy("PROD", "STATS", full, WithContext(ctx), WithStartDelay(time.Hour), withClock(clk))
+			if !waitScheduled(clk) {
+				return false
+			}
+
+			canceled := time.Now()
+			cancel()
+			err = ctl.Wait()
+			if !errors.Is(err, context.Canceled) || time.Since(canceled) >= 100*time.Millisecond {
+				return false
+			}
+
+			// задержка WithStartDelay отсчитывается от запуска, время подключения входит в неё
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false).SetConnectDelay(500 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			started := time.Now()
+			if err := CopyTable("PROD", "STATS", full, WithStartDelay(600*time.Millisecond)); err != nil {
+				return false
+			}
+			elapsed := time.Since(started)
+			return elapsed >= 600*time.Millisecond && elapsed < 900*time.Millisecond
+		},
+	},
+	{
+		name: "Ожидаются повторы ошибок драйвера, отмеченных классификатором (WithRetryable), и только их",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			errDeadlock := errors.New("deadlock detected")
+			errSyntax := errors.New("syntax error")
+
+			cfg := newCopyConfig([]CopyOption{WithRetryable(func(err error) bool {
+				return errors.Is(err, errDeadlock)
+			})})
+			policy := retryPolicy{maxRetries: 3, jitter: JitterNone, retryable: cfg.retryable}
+
+			// возвращает кол-во вызовов и итоговую ошибку, fn падает с errs по очереди
+			run := func(errs ...error) (int, error) {
+				calls := 0
+				_, err := withRetry(context.Background(), policy, func() (struct{}, error) {
+					calls++
+					if calls <= len(errs) {
+						return struct{}{}, errs[calls-1]
+					}
+					return struct{}{}, nil
+				})
+				return calls, err
+			}
+
+			calls, err := run(errDeadlock, ErrDBTemporal)
+			if err != nil || calls != 3 {
+				return false
+			}
+
+			calls, err = run(errDeadlock, errDeadlock, errDeadlock, errDeadlock)
+			if !errors.Is(err, errDeadlock) || calls != 4 {
+				return false
+			}
+
+			calls, err = run(errSyntax)
+			return errors.Is(err, errSyntax) && calls == 1
+		},
+	},
+	{
+		name: "Ожидается блокировка сборщика при достижении лимита батчей в работе (WithMaxInFlightBatches)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 100_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveDelay(50 * time.Millisecond)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			const maxInFlight = 3
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			ctl := StartCopy("PROD", "STATS", full, WithReadahead(20), WithMaxInFlightBatches(maxInFlight))
+
+			// разница между загруженными и сохранёнными батчами не превышает лимит на всём ходу переливки
+			maxDelta := 0
+			for {
+				select {
+				case <-ctl.done:
+					if ctl.Wait() != nil || dbs.Stats.GetDataLen() != dbs.Prod.GetDataLen() {
+						return false
+					}
+					return maxDelta == maxInFlight
+				default:
+				}
+
+				// загрузки читаем первыми: сохранения, успевшие завершиться между чтениями,
+				// только уменьшают разницу и не дают ложного превышения
+				loadedRows := 0
+				for _, n := range dbs.Prod.GetLoadСallNums() {
+					loadedRows += n
+				}
+				saved := len(dbs.Stats.GetSaveСallNums())
+				delta := (loadedRows+batchSize-1)/batchSize - saved
+				if delta > maxInFlight {
+					return false
+				}
+				maxDelta = max(maxDelta, delta)
+				time.Sleep(time.Millisecond)
+			}
+		},
+	},
+	{
+		name: "Ожидается сохранение только выбранных колонок вместе с id (WithKeepColumns)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 15_000}, false, false, false).SetRowColumns("name", 42, true)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithKeepColumns([]int{3, 1})); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			saved, err := dbs.Stats.LoadRows(context.Background(), 0, 20_000)
+			if err != nil || len(saved) != 3 {
+				return false
+			}
+			for _, r := range saved {
+				id, ok := r[0].(mockRow)
+				if !ok || id.id == 0 || !reflect.DeepEqual(r[1:], Row{true, "name"}) {
+					return false
+				}
+			}
+
+			// исходные строки в PROD не изменились
+			src, err := dbs.Prod.LoadRows(context.Background(), 1, 2)
+			if err != nil || len(src) != 1 || len(src[0]) != 4 {
+				return false
+			}
+
+			err = CopyTable("PROD", "STATS", full, WithKeepColumns([]int{4}))
+			return err != nil && strings.Contains(err.Error(), "keep column 4: out of range")
+		},
+	},
+	{
+		name: "Ожидается отправка недобранного батча по таймауту на разреженных id (WithBatchTimeoutFlush)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// время до первого SaveRows: батч из строки 1 добирается 100 пустыми LoadRows по 5мс
+			firstSave := func(opts ...CopyOption) time.Duration {
+				NewMockDatabase("PROD", []uint64{1, 1_000_000}, false, false, false).SetLoadDelay(5 * time.Millisecond)
+				stats := NewMockDatabase("STATS", []uint64{}, false, false, false)
+
+				started := time.Now()
+				ctl := StartCopy("PROD", "STATS", full, opts...)
+				for len(stats.GetSaveСallNums()) == 0 && time.Since(started) < 5*time.Second {
+					time.Sleep(time.Millisecond)
+				}
+				elapsed := time.Since(started)
+
+				if err := ctl.Wait(); err != nil || stats.GetDataLen() != 2 {
+					return -1
+				}
+				return elapsed
+			}
+
+			stalled := firstSave()
+			flushed := firstSave(WithBatchTimeoutFlush(30 * time.Millisecond))
+
+			return stalled >= 400*time.Millisecond && flushed >= 0 && flushed < 150*time.Millisecond
+		},
+	},
+	{
+		name: "Ожидается быстрый отказ второй параллельной переливки в ту же таблицу (WithLocker)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 50_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveDelay(100 * time.Millisecond)
+			NewMockDatabase("STATS_2", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			locker := NewLocalLocker()
+
+			first := StartCopy("PROD", "STATS", full, WithLocker(locker))
+			time.Sleep(20 * time.Millisecond)
+
+			started := time.Now()
+			err := CopyTable("PROD", "STATS", full, WithLocker(locker))
+			if !errors.Is(err, ErrAlreadyRunning) || time.Since(started) > 50*time.Millisecond {
+				return false
+			}
+
+			// другая целевая база блокировкой не затронута
+			if err := CopyTable("PROD", "STATS_2", full, WithLocker(locker)); err != nil {
+				return false
+			}
+
+			// после завершения первой переливки блокировка освобождается
+			if err := first.Wait(); err != nil {
+				return false
+			}
+			return CopyTable("PROD", "STATS", full, WithLocker(locker)) == nil
+		},
+	},
+	{
+		name: "Ожидается остановка к сроку с частичной статистикой и токеном продолжения (WithDeadline)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 100_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false).SetLoadDelay(20 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			ctl := StartCopy("PROD", "STATS", full, WithDeadline(time.Now().Add(80*time.Millisecond), 20*time.Millisecond))
+			if err := ctl.Wait(); !errors.Is(err, ErrDeadlineReached) {
+				return false
+			}
+
+			// остановились штатно: всё, что учтено в статистике, действительно сохранено
+			res := ctl.Result()
+			if res.RowsCopied == 0 || res.RowsCopied >= 100_000 || uint64(dbs.Stats.GetDataLen()) != res.RowsCopied {
+				return false
+			}
+
+			// по токену переливка доходит до конца
+			if err := CopyTable("PROD", "STATS", false, WithResumeToken(res.ResumeToken)); err != nil {
+				return false
+			}
+			return dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается обнаружение молча потерянной записи чтением после сохранения (WithReadAfterWrite)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 25_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithReadAfterWrite()); err != nil {
+				return false
+			}
+
+			// без проверки потеря строки проходит незамеченной
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetDropWrites(12_345)
+			if err := CopyTable("PROD", "STATS", full); err != nil {
+				return false
+			}
+
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetDropWrites(12_345)
+			err := CopyTable("PROD", "STATS", full, WithReadAfterWrite())
+			return errors.Is(err, ErrWriteNotConfirmed) && strings.Contains(err.Error(), "row 12345 missing")
+		},
+	},
+	{
+		name: "Ожидаются параллельные LoadRows по поддиапазонам батча с сохранением порядка id (WithLoadConcurrency)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 0, 30_000)
+			for i := range 30_000 {
+				prodIds = append(prodIds, uint64(i*2+1))
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false).SetLoadDelay(10 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			var unsorted atomic.Bool
+			checkSorted := func(rows []Row) {
+				ok := slices.IsSortedFunc(rows, func(a, b Row) int {
+					return cmp.Compare(a[0].(mockRow).id, b[0].(mockRow).id)
+				})
+				if !ok {
+					unsorted.Store(true)
+				}
+			}
+
+			err := CopyTable("PROD", "STATS", full, WithLoadConcurrency(4), withBatchTransform(checkSorted))
+			if err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			return dbs.Prod.GetLoadParallel() >= 2 && !unsorted.Load() && dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается точная сводка попыток, успехов после повтора и исчерпаний в CopyResult.RetryMetrics",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, true, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// подключения 2, GetMaxID, GetMinID, LoadRows с одной временной ошибкой 2, SaveRows
+			ctl := StartCopy("PROD", "STATS", full, WithRetryJitter(JitterNone, nil))
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+			expected := RetryMetrics{Attempts: 7, SuccessesAfterRetry: 1, BackoffTotal: backoffBaseForRetries}
+			if ctl.Result().RetryMetrics != expected {
+				return false
+			}
+
+			// подключение к PROD исчерпывает 3 попытки с паузами 1 и 2мс,
+			// параллельное подключение к STATS успевает с первой попытки
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false).SetConnectErrs(10)
+			ctl = StartCopy("PROD", "STATS", full, WithRetryJitter(JitterNone, nil), WithConnectRetry(3, time.Millisecond))
+			if err := ctl.Wait(); !errors.Is(err, ErrDBTemporal) {
+				return false
+			}
+			expected = RetryMetrics{Attempts: 4, Exhaustions: 1, BackoffTotal: 3 * time.Millisecond}
+			return ctl.Result().RetryMetrics == expected
+		},
+	},
+	{
+		name: "Ожидается ровно один вызов WithOnComplete с ошибкой подключения и с итоговой статистикой при успехе",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 15_000}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			var calls int
+			var gotResult CopyResult
+			var gotErr error
+			onComplete := WithOnComplete(func(result CopyResult, err error) {
+				calls++
+				gotResult, gotErr = result, err
+			})
+
+			err := CopyTable("PROD", "NO_SUCH_DB", full, onComplete)
+			if err == nil || calls != 1 || gotErr != err {
+				return false
+			}
+
+			calls = 0
+			err = CopyTable("PROD", "STATS", full, onComplete)
+			return err == nil && calls == 1 && gotErr == nil &&
+				gotResult.RowsCopied == 4 && gotResult.BatchesWritten == 1
+		},
+	},
+	{
+		name: "Ожидается отказ переливки в STATS, заполненную из другого источника (WithExpectedSourceID)",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false)
+			NewMockDatabase("PROD_B", []uint64{100, 200}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithExpectedSourceID("cluster-a")); err != nil {
+				return false
+			}
+
+			// повторная переливка из того же источника проходит
+			if err := CopyTable("PROD", "STATS", full, WithExpectedSourceID("cluster-a")); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			err = CopyTable("PROD_B", "STATS", full, WithExpectedSourceID("cluster-b"))
+			return errors.Is(err, ErrSourceMismatch) && dbs.Stats.GetDataLen() == 3
+		},
+	},
+	{
+		name: "Ожидается не больше заданного кол-ва одновременных LoadRows (WithMaxOpenRanges)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false).SetLoadDelay(10 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			err := CopyTable("PROD", "STATS", full, WithLoadConcurrency(8), WithMaxOpenRanges(2))
+			if err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			return dbs.Prod.GetLoadParallel() == 2 && dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается отказ в продолжении по токену, выданному до полной переливки (WithReplayProtection)",
+		full: false,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3, 4, 5}, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// возвращает токен полной переливки
+			fullCopy := func() ResumeToken {
+				ctl := StartCopy("PROD", "STATS", true, WithReplayProtection())
+				if err := ctl.Wait(); err != nil {
+					return ""
+				}
+				return ctl.Result().ResumeToken
+			}
+
+			stale := fullCopy()
+			fresh := fullCopy()
+			if stale == "" || fresh == "" {
+				return false
+			}
+
+			err := CopyTable("PROD", "STATS", full, WithReplayProtection(), WithResumeToken(stale))
+			if !errors.Is(err, ErrStaleCheckpoint) {
+				return false
+			}
+			return CopyTable("PROD", "STATS", full, WithReplayProtection(), WithResumeToken(fresh)) == nil
+		},
+	},
+	{
+		name: "Ожидается параллельное подключение к базам и закрытие открытого подключения при ошибке второго",
+		full: true,
+		prepare: func() struct{} {
+			NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false).SetConnectDelay(100 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetConnectDelay(100 * time.Millisecond)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			started := time.Now()
+			if err := CopyTable("PROD", "STATS", full); err != nil {
+				return false
+			}
+			if elapsed := time.Since(started); elapsed >= 180*time.Millisecond {
+				return false
+			}
+
+			// PROD не найден, а подключение к STATS успело открыться
+			err = CopyTable("NO_SUCH_DB", "STATS", full)
+			return err != nil && strings.Contains(err.Error(), "connect to PROD") &&
+				dbs.Prod.GetOpenConns() == 0 && dbs.Stats.GetOpenConns() == 0
+		},
+	},
+	{
+		name: "Ожидается обнаружение повторяющихся id из PROD (WithDetectDuplicates)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false).AddDuplicateRow(25_000_000, 7)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// без опции дубликат молча перезаписывается
+			if err := CopyTable("PROD", "STATS", full); err != nil {
+				return false
+			}
+
+			err := CopyTable("PROD", "STATS", full, WithDetectDuplicates())
+			if !errors.Is(err, ErrDuplicateID) || !strings.Contains(err.Error(), "row 7") {
+				return false
+			}
+
+			// разреженный битсет для широких диапазонов тоже находит повтор, в том числе в соседних блоках
+			sparse := newIDSet(0, 1<<40)
+			if sparse.add(42) || sparse.add(42+sparseChunkIDs) || sparse.add(1<<39) ||
+				!sparse.add(42) || !sparse.add(1<<39) {
+				return false
+			}
+
+			// по одному id на блок: блоков не больше sparseChunkLimit, остальное - в фильтре Блума,
+			// который тоже находит повтор
+			bounded := newSparseIDSet()
+			for i := range uint64(2 * sparseChunkLimit) {
+				if bounded.add(i * sparseChunkIDs) {
+					return false
+				}
+			}
+			return len(bounded.chunks) == sparseChunkLimit && bounded.bloom != nil &&
+				bounded.add(0) && bounded.add((2*sparseChunkLimit-1)*sparseChunkIDs)
+		},
+	},
+	{
+		name: "Ожидается чистая переливка с WithDetectDuplicates на диапазоне id шире exactIDSetLimit",
+		full: true,
+		prepare: func() struct{} {
+			// два плотных участка по 200К строк, разнесённые дальше exactIDSetLimit
+			prodIds := make([]uint64, 0, 400_000)
+			for i := range uint64(200_000) {
+				prodIds = append(prodIds, i+1, 4*exactIDSetLimit+i+1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithDetectDuplicates()); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			return err == nil && dbs.Stats.GetDataLen() == 400_000
+		},
+	},
+	{
+		name: "Ожидается отсутствие ложных срабатываний WithDetectDuplicates на 1М уникальных строк",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 1_000_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithDetectDuplicates()); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+			return dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается сохранение позиции без дыр при ошибке переливки (WithCheckpointOnError)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 100_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveErrFor(45_000, errDiskFull)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			var checkpoints []ResumeToken
+			save := func(t ResumeToken) error {
+				checkpoints = append(checkpoints, t)
+				return nil
+			}
+
+			err := CopyTable("PROD", "STATS", full, WithCheckpointOnError(save))
+			if !errors.Is(err, errDiskFull) || len(checkpoints) != 1 {
+				return false
+			}
+
+			st, err := decodeResumeToken(checkpoints[0])
+			if err != nil || st.NextID > 45_000 {
+				return false
+			}
+
+			// все строки до позиции из токена действительно в STATS
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+			saved, err := dbs.Stats.LoadRows(context.Background(), 0, st.NextID)
+			if err != nil || uint64(len(saved)) != max(st.NextID, 1)-1 {
+				return false
+			}
+
+			// при успешной переливке колбэк не вызывается
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return CopyTable("PROD", "STATS", full, WithCheckpointOnError(save)) == nil && len(checkpoints) == 1
+		},
+	},
+	{
+		name: "Ожидается отмена и повтор зависшего SaveRows вместо дедлока (WithSaveTimeout)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 25_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false).SetSaveHangs(2)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			ctl := StartCopy("PROD", "STATS", full, WithSaveTimeout(50*time.Millisecond), WithRetryJitter(JitterNone, nil))
+
+			select {
+			case <-ctl.done:
+			case <-time.After(3 * time.Second):
+				return false
+			}
+
+			res := ctl.Result()
+			return ctl.Wait() == nil && res.Retries == 2 && res.RowsCopied == 25_000 &&
+				dbs.Stats.GetDataLen() == dbs.Prod.GetDataLen()
+		},
+	},
+	{
+		name: "Ожидается продолжение с принудительно заданного id без учёта STATS (WithForceStartID)",
+		full: false,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{1, 2, 3}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			if err := CopyTable("PROD", "STATS", full, WithForceStartID(25_001)); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+
+			// перелит только хвост [25001, 30000], пропуск между 3 и 25001 остался
+			skipped, err := dbs.Stats.LoadRows(context.Background(), 4, 25_001)
+			if err != nil || len(skipped) != 0 || dbs.Stats.GetDataLen() != 3+5_000 {
+				return false
+			}
+
+			err = CopyTable("PROD", "STATS", full, WithForceStartID(30_001))
+			return err != nil && strings.Contains(err.Error(), "beyond PROD max ID")
+		},
+	},
+	{
+		name: "Ожидается процент прогресса по подсказке и предупреждение о расхождении (WithExpectedRows)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 20_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// подсказка вдвое больше факта: ожидаем 50% в конце и предупреждение
+			logger := &mockLogger{}
+			ctl := StartCopy("PROD", "STATS", full, WithExpectedRows(40_000), WithLogger(logger))
+
+			var last CopyProgress
+			for p := range ctl.ProgressChan() {
+				if p.Percent != float64(p.RowsCopied)/40_000*100 {
+					return false
+				}
+				last = p
+			}
+			if err := ctl.Wait(); err != nil || last.Percent != 50 {
+				return false
+			}
+
+			warned := func(lines []string) bool {
+				for _, line := range lines {
+					if strings.Contains(line, "copy warning") && strings.Contains(line, "expected about 40000") {
+						return true
+					}
+				}
+				return false
+			}
+			if !warned(logger.Lines()) {
+				return false
+			}
+
+			// точная подсказка - без предупреждения
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			logger = &mockLogger{}
+			err := CopyTable("PROD", "STATS", full, WithExpectedRows(20_000), WithLogger(logger))
+			return err == nil && !warned(logger.Lines())
+		},
+	},
+	{
+		name: "Ожидается одинаковый полный набор данных в обеих целях (CopyTableDual)",
+		full: false,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 25_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", []uint64{1, 2, 3}, false, false, false)
+			NewMockDatabase("STATS_NEW", []uint64{}, false, false, true)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			// новая цель пуста и с временной ошибкой записи: продолжение идёт от неё, батч повторяется
+			if err := CopyTableDual("PROD", "STATS", "STATS_NEW", full, WithConnections(2)); err != nil {
+				return false
+			}
+
+			ctx := context.Background()
+			dbA, err := Connect(ctx, "STATS")
+			if err != nil {
+				return false
+			}
+			defer dbA.Close()
+			dbB, err := Connect(ctx, "STATS_NEW")
+			if err != nil {
+				return false
+			}
+			defer dbB.Close()
+
+			rowsA, errA := dbA.LoadRows(ctx, 0, 25_001)
+			rowsB, errB := dbB.LoadRows(ctx, 0, 25_001)
+			if errA != nil || errB != nil || len(rowsA) != 25_000 || len(rowsB) != 25_000 {
+				return false
+			}
+			for i := range rowsA {
+				if idA, _ := rowID(rowsA[i]); idA != uint64(i+1) {
+					return false
+				}
+				if idB, _ := rowID(rowsB[i]); idB != uint64(i+1) {
+					return false
+				}
+			}
+
+			// постоянная ошибка записи в одну из целей валит всю переливку
+			NewMockDatabase("STATS_NEW", []uint64{}, false, false, false).SetSaveErrFor(100, errDiskFull)
+			err = CopyTableDual("PROD", "STATS", "STATS_NEW", true)
+			return errors.Is(err, errDiskFull)
+		},
+	},
+	{
+		name: "Ожидается сверка STATS с отстающим токеном по каждой политике (WithResumeVerification)",
+		full: false,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// токен отстаёт: по нему сохранено до 10 000, а в STATS уже до 20 000
+			token := encodeResumeToken(resumeState{Table: tableName, Source: "PROD", NextID: 10_001, SourceMinID: 1})
+			firstSaved := func(policy ResumeVerifyPolicy) (uint64, error) {
+				NewMockDatabase("PROD", prodIds, false, false, false)
+				stats := NewMockDatabase("STATS", prodIds[:20_000], false, false, false)
+				if err := CopyTable("PROD", "STATS", full, WithResumeToken(token), WithResumeVerification(policy)); err != nil {
+					return 0, err
+				}
+				return slices.Min(stats.GetSaveFirstIDs()), nil
+			}
+
+			if _, err := firstSaved(ResumeAbort); !errors.Is(err, ErrCheckpointDivergence) {
+				return false
+			}
+			if first, err := firstSaved(ResumeTrustCheckpoint); err != nil || first != 10_001 {
+				return false
+			}
+			// STATS впереди: продолжение от max ID пропустило бы возможные дыры после позиции токена
+			if first, err := firstSaved(ResumeTrustStats); err != nil || first != 10_001 {
+				return false
+			}
+
+			// STATS позади токена: ResumeTrustStats доливает от max ID в STATS
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			behind := NewMockDatabase("STATS", prodIds[:10_000], false, false, false)
+			ahead := encodeResumeToken(resumeState{Table: tableName, Source: "PROD", NextID: 20_001, SourceMinID: 1})
+			if err := CopyTable("PROD", "STATS", full, WithResumeToken(ahead), WithResumeVerification(ResumeTrustStats)); err != nil ||
+				slices.Min(behind.GetSaveFirstIDs()) != 10_000 {
+				return false
+			}
+
+			// без расхождения позиция токена принимается при любой политике
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", prodIds[:10_000], false, false, false)
+			return CopyTable("PROD", "STATS", full, WithResumeToken(token), WithResumeVerification(ResumeAbort)) == nil
+		},
+	},
+	{
+		name: "Ожидается сверка токена инкрементальной переливки без ложного расхождения и без пропуска дыр (WithResumeVerification)",
+		full: false,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			prodIds := make([]uint64, 30_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+
+			// инкрементальная переливка падает на первом же батче: последняя строка STATS
+			// уже сохранена, и токен не должен указывать на неё
+			var checkpoints []ResumeToken
+			save := func(t ResumeToken) error {
+				checkpoints = append(checkpoints, t)
+				return nil
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			NewMockDatabase("STATS", prodIds[:10_000], false, false, false).SetSaveErrFor(10_000, errDiskFull)
+			err := CopyTable("PROD", "STATS", full, WithCheckpointOnError(save))
+			if !errors.Is(err, errDiskFull) || len(checkpoints) != 1 {
+				return false
+			}
+			if st, err := decodeResumeToken(checkpoints[0]); err != nil || st.NextID != 10_001 {
+				return false
+			}
+
+			NewMockDatabase("STATS", prodIds[:10_000], false, false, false)
+			if err := CopyTable("PROD", "STATS", full, WithResumeToken(checkpoints[0]), WithResumeVerification(ResumeAbort)); err != nil {
+				return false
+			}
+
+			// STATS впереди токена с дырой 10 001..15 000: ResumeTrustStats продолжает от токена, а не от max ID
+			token := encodeResumeToken(resumeState{Table: tableName, Source: "PROD", NextID: 10_001, SourceMinID: 1})
+			NewMockDatabase("PROD", prodIds, false, false, false)
+			holed := NewMockDatabase("STATS", append(slices.Clone(prodIds[:10_000]), prodIds[15_000:20_000]...), false, false, false)
+			if err := CopyTable("PROD", "STATS", full, WithResumeToken(token), WithResumeVerification(ResumeTrustStats)); err != nil {
+				return false
+			}
+			return slices.Min(holed.GetSaveFirstIDs()) == 10_001 && holed.GetDataLen() == len(prodIds)
+		},
+	},
+	{
+		name: "Ожидается переподключение к PROD после обрыва соединения (WithReconnectOnTemporalError)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			prodIds := make([]uint64, 25_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			isConnReset := func(err error) bool {
+				return strings.Contains(err.Error(), "connection reset")
+			}
+
+			// без переподключения повторы бьются в сломанное подключение
+			NewMockDatabase("PROD", prodIds, false, false, false).SetSerialConnections(true).SetConnResets(1)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			if err := CopyTable("PROD", "STATS", full); !errors.Is(err, errConnReset) {
+				return false
+			}
+
+			prod := NewMockDatabase("PROD", prodIds, false, false, false).SetSerialConnections(true).SetConnResets(1)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			if err := CopyTable("PROD", "STATS", full, WithReconnectOnTemporalError(isConnReset)); err != nil {
+				return false
+			}
+
+			dbs, err := getMockDatabases()
+			if err != nil {
+				return false
+			}
+			// сломанное подключение закрыто при замене, новое - по завершении, утечек нет
+			return dbs.Stats.GetDataLen() == 25_000 && prod.GetOpenConns() == 0
+		},
+	},
+	{
+		name: "Ожидается переподключение без блокировки других горутин и закрытие старого подключения после них (WithReconnectOnTemporalError)",
+		full: true,
+		prepare: func() struct{} {
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			ctx := context.Background()
+			prod := NewMockDatabase("PROD", []uint64{1, 2, 3}, false, false, false).SetSerialConnections(true).SetConnResets(1)
+
+			conn, err := Connect(ctx, "PROD")
+			if err != nil {
+				return false
+			}
+			dialing := make(chan struct{})
+			dialGate := make(chan struct{})
+			dial := func(ctx context.Context) (Database, error) {
+				close(dialing)
+				<-dialGate
+				return Connect(ctx, "PROD")
+			}
+			r := newReconnectingDB(conn, dial, func(err error) bool { return errors.Is(err, errConnReset) })
+
+			loaded := make(chan error, 1)
+			go func() {
+				_, err := r.LoadRows(ctx, 1, 4)
+				loaded <- err
+			}()
+			<-dialing
+
+			// пока открывается новое подключение, остальные горутины берут текущее без ожидания
+			acquired := make(chan *sharedConn, 1)
+			go func() { acquired <- r.acquire() }()
+			var held *sharedConn
+			select {
+			case held = <-acquired:
+			case <-time.After(time.Second):
+				return false
+			}
+
+			close(dialGate)
+			if err := <-loaded; !errors.Is(err, errConnReset) {
+				return false
+			}
+
+			// старое подключение подменено, но закрывается только за последним пользователем
+			if held.db != conn || prod.GetOpenConns() != 2 {
+				return false
+			}
+			r.release(held)
+			if prod.GetOpenConns() != 1 {
+				return false
+			}
+
+			if _, err := r.LoadRows(ctx, 1, 4); err != nil {
+				return false
+			}
+			r.Close()
+			return prod.GetOpenConns() == 0
+		},
+	},
+	{
+		name: "Ожидаются промежуточные строки статистики с правдоподобной скоростью (WithStatsInterval)",
+		full: true,
+		prepare: func() struct{} {
+			prodIds := make([]uint64, 50_000)
+			for i := range prodIds {
+				prodIds[i] = uint64(i + 1)
+			}
+			NewMockDatabase("PROD", prodIds, false, false, false).SetLoadDelay(20 * time.Millisecond)
+			NewMockDatabase("STATS", []uint64{}, false, false, false)
+			return struct{}{}
+		},
+		check: func(full bool) bool {
+			clk := newMockClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			logger := &mockLogger{}
+			ctl := StartCopy("PROD", "STATS", full, withClock(clk), WithStatsInterval(time.Second, logger))
+
+			for running := true; running; {
+				select {
+				case <-ctl.done:
+					running = false
+				case <-time.After(5 * time.Millisecond):
+					clk.Advance(time.Second)
+				}
+			}
+			if err := ctl.Wait(); err != nil {
+				return false
+			}
+
+			// между отчётами проходит не меньше секунды, так что скорость не выше прироста строк
+			lines := logger.Lines()
+			var prevRows uint64
+			moving := false
+			for _, line := range lines {
+				var rows, retries, nextID uint64
+				var rate float64
+				if _, err := fmt.Sscanf(line, "copy stats: rows=%d rate=%f rows/s retries=%d next_id=%d", &rows, &rate, &retries, &nextID); err != nil {
+					return false
+				}
+				if rows < prevRows || rows > 50_000 || rate < 0 || rate > float64(rows-prevRows) {
+					return false
+				}
+				moving = moving || rate > 0
+				prevRows = rows
+			}
+			return len(lines) > 0 && moving
+		},
+	},
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// reconnectingDB - подключение, которое подменяется новым, если ошибка операции его сломала
+// (WithReconnectOnTemporalError). Сама ошибка возвращается как есть: повтор делает withRetry,
+// уже по новому подключению. Подключение общее для горутин, поэтому подмена под mu, а
+// новое подключение открывается вне mu, чтобы остальные горутины не ждали весь redial.
+type reconnectingDB struct {
+	mu          sync.Mutex
+	conn        *sharedConn
+	dial        func(context.Context) (Database, error)
+	isConnFatal func(error) bool
+}
+
+// sharedConn - подключение со счётчиком горутин, выполняющих на нём операцию.
+// Подменённое подключение закрывается, только когда последняя из них закончит.
+type sharedConn struct {
+	db        Database
+	users     int
+	redialing bool // подключение сломано, новое уже открывается
+	retired   bool // подключение подменено и закрывается после users == 0
+}
+
+func newReconnectingDB(db Database, dial func(context.Context) (Database, error), isConnFatal func(error) bool) *reconnectingDB {
+	return &reconnectingDB{conn: &sharedConn{db: db}, dial: dial, isConnFatal: isConnFatal}
+}
+
+// acquire отдаёт текущее подключение и учитывает вызывающего среди его пользователей
+func (r *reconnectingDB) acquire() *sharedConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.conn.users++
+	return r.conn
+}
+
+// release снимает учёт пользователя и закрывает подменённое подключение за последним из них
+func (r *reconnectingDB) release(conn *sharedConn) {
+	r.mu.Lock()
+	conn.users--
+	closeNow := conn.retired && conn.users == 0
+	r.mu.Unlock()
+
+	if closeNow {
+		conn.db.Close()
+	}
+}
+
+// check переоткрывает подключение conn, если err его сломала. Если на одном подключении
+// ошибку получили сразу несколько горутин, переоткрывает только первая.
+func (r *reconnectingDB) check(ctx context.Context, conn *sharedConn, err error) error {
+	if err == nil || !errors.Is(err, ErrDBTemporal) || !r.isConnFatal(err) {
+		return err
+	}
+
+	r.mu.Lock()
+	if r.conn != conn || conn.redialing {
+		r.mu.Unlock()
+		return err
+	}
+	conn.redialing = true
+	r.mu.Unlock()
+
+	fresh, dialErr := r.dial(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if dialErr != nil {
+		// следующая ошибка на этом подключении попробует ещё раз
+		conn.redialing = false
+		return errors.Join(err, fmt.Errorf("reconnect: %w", dialErr))
+	}
+	// вызывающий сам в числе пользователей conn, поэтому закроет его release
+	conn.retired = true
+	r.conn = &sharedConn{db: fresh}
+
+	return err
+}
+
+// reconnectCall выполняет операцию на текущем подключении и проверяет её ошибку
+func reconnectCall[T any](ctx context.Context, r *reconnectingDB, op func(Database) (T, error)) (T, error) {
+	conn := r.acquire()
+	defer r.release(conn)
+
+	v, err := op(conn.db)
+	return v, r.check(ctx, conn, err)
+}
+
+func (r *reconnectingDB) GetMaxID(ctx context.Context) (uint64, error) {
+	return reconnectCall(ctx, r, func(db Database) (uint64, error) {
+		return db.GetMaxID(ctx)
+	})
+}
+
+func (r *reconnectingDB) GetMinID(ctx context.Context) (uint64, error) {
+	return reconnectCall(ctx, r, func(db Database) (uint64, error) {
+		return db.GetMinID(ctx)
+	})
+}
+
+func (r *reconnectingDB) LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, error) {
+	return reconnectCall(ctx, r, func(db Database) ([]Row, error) {
+		return db.LoadRows(ctx, minID, maxID)
+	})
+}
+
+func (r *reconnectingDB) LoadRowsByIDs(ctx context.Context, ids []uint64) ([]Row, error) {
+	return reconnectCall(ctx, r, func(db Database) ([]Row, error) {
+		return db.LoadRowsByIDs(ctx, ids)
+	})
+}
+
+func (r *reconnectingDB) SaveRows(ctx context.Context, rows []Row) error {
+	_, err := reconnectCall(ctx, r, func(db Database) (struct{}, error) {
+		return struct{}{}, db.SaveRows(ctx, rows)
+	})
+	return err
+}
+
+func (r *reconnectingDB) Ping(ctx context.Context) error {
+	_, err := reconnectCall(ctx, r, func(db Database) (struct{}, error) {
+		return struct{}{}, db.Ping(ctx)
+	})
+	return err
+}
+
+func (r *reconnectingDB) GetMeta(ctx context.Context, key string) (string, error) {
+	return reconnectCall(ctx, r, func(db Database) (string, error) {
+		return db.GetMeta(ctx, key)
+	})
+}
+
+func (r *reconnectingDB) SetMeta(ctx context.Context, key, value string) error {
+	_, err := reconnectCall(ctx, r, func(db Database) (struct{}, error) {
+		return struct{}{}, db.SetMeta(ctx, key, value)
+	})
+	return err
+}
+
+func (r *reconnectingDB) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn.db.Close()
+}
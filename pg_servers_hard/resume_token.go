@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ErrResumeMismatch - токен возобновления (WithResumeToken) повреждён либо выдан для другого
+// источника, или источник с тех пор несовместимо изменился
+var ErrResumeMismatch = errors.New("resume token does not match source")
+
+// ErrStaleCheckpoint - токен возобновления выдан до последней полной переливки в STATS
+// (WithReplayProtection), продолжать с его позиции нельзя
+var ErrStaleCheckpoint = errors.New("resume token is from a previous copy epoch")
+
+// ErrCheckpointDivergence - STATS не сходится с позицией токена возобновления (WithResumeVerification)
+var ErrCheckpointDivergence = errors.New("STATS diverges from resume checkpoint")
+
+// tableName - таблица, которую переливает CopyTable
+const tableName = "profiles"
+
+// ResumeToken - непрозрачный токен для возобновления переливки между запусками.
+// Выдаётся в CopyResult, его можно сохранить как строку и передать в WithResumeToken.
+type ResumeToken string
+
+// resumeState - содержимое ResumeToken
+type resumeState struct {
+	Table  string `json:"table"`
+	Source string `json:"source"`
+	// все строки с id < NextID гарантированно сохранены в STATS
+	NextID uint64 `json:"next_id"`
+	// отпечаток источника: минимальный id в PROD на момент выдачи токена
+	SourceMinID uint64 `json:"source_min_id"`
+	// эпоха STATS на момент выдачи токена, растёт с каждой полной переливкой (WithReplayProtection)
+	Epoch uint64 `json:"epoch,omitempty"`
+	// строки из [NextID, LoadedTo), загруженные, но не подтверждённые сохранением: при возобновлении
+	// они дочитываются по id (LoadRowsByIDs), а диапазон сканируется только с LoadedTo.
+	// Остальные id этого диапазона либо уже в STATS, либо отсутствуют в PROD
+	Missing  []idRun `json:"missing,omitempty"`
+	LoadedTo uint64  `json:"loaded_to,omitempty"`
+}
+
+// idRun - серия подряд идущих id [first, last], так токен остаётся компактным на плотных id
+type idRun [2]uint64
+
+// compactIDs сворачивает отсортированные id в серии
+func compactIDs(ids []uint64) []idRun {
+	var runs []idRun
+	for _, id := range ids {
+		if n := len(runs); n > 0 && runs[n-1][1]+1 == id {
+			runs[n-1][1] = id
+			continue
+		}
+		runs = append(runs, idRun{id, id})
+	}
+	return runs
+}
+
+// expandIDs разворачивает серии обратно в id
+func expandIDs(runs []idRun) []uint64 {
+	var ids []uint64
+	for _, r := range runs {
+		for id := r[0]; id <= r[1]; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func encodeResumeToken(st resumeState) ResumeToken {
+	data, _ := json.Marshal(st)
+	return ResumeToken(base64.RawURLEncoding.EncodeToString(data))
+}
+
+func decodeResumeToken(t ResumeToken) (resumeState, error) {
+	var st resumeState
+
+	data, err := base64.RawURLEncoding.DecodeString(string(t))
+	if err != nil {
+		return st, fmt.Errorf("decode resume token: %w", ErrResumeMismatch)
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("decode resume token: %w", ErrResumeMismatch)
+	}
+	return st, nil
+}
+
+// validate сверяет токен с текущим состоянием источника
+func (st resumeState) validate(source string, sourceMinID, sourceMaxID uint64) error {
+	switch {
+	case st.Table != tableName || st.Source != source:
+		return fmt.Errorf("token for %s/%s, copying %s/%s: %w", st.Source, st.Table, source, tableName, ErrResumeMismatch)
+	case st.SourceMinID != sourceMinID:
+		return fmt.Errorf("token source min ID %d, PROD min ID %d: %w", st.SourceMinID, sourceMinID, ErrResumeMismatch)
+	case st.NextID > sourceMaxID+1:
+		return fmt.Errorf("token next ID %d, PROD max ID %d: %w", st.NextID, sourceMaxID, ErrResumeMismatch)
+	case st.LoadedTo != 0 && (st.LoadedTo < st.NextID || st.LoadedTo > sourceMaxID+1):
+		return fmt.Errorf("token loaded to ID %d, next ID %d, PROD max ID %d: %w", st.LoadedTo, st.NextID, sourceMaxID, ErrResumeMismatch)
+	}
+	for _, r := range st.Missing {
+		if r[0] > r[1] || r[0] < st.NextID || r[1] >= st.LoadedTo {
+			return fmt.Errorf("token missing IDs [%d, %d] outside [%d, %d): %w", r[0], r[1], st.NextID, st.LoadedTo, ErrResumeMismatch)
+		}
+	}
+	return nil
+}
+
+// ResumeVerifyPolicy - как поступать, если при возобновлении STATS не сходится с токеном
+type ResumeVerifyPolicy int
+
+const (
+	// ResumeTrustStats - продолжать от max ID в STATS, как без токена, если STATS отстаёт от токена.
+	// Если STATS впереди, продолжать от позиции токена: строки между ней и max ID в STATS
+	// могли быть не сохранены, и продолжение от max ID пропустило бы их
+	ResumeTrustStats ResumeVerifyPolicy = iota
+	// ResumeTrustCheckpoint - продолжать от позиции токена
+	ResumeTrustCheckpoint
+	// ResumeAbort - прервать переливку с ErrCheckpointDivergence
+	ResumeAbort
+)
+
+// reconcileResume сверяет STATS с позицией токена nextID и возвращает стартовый id по политике.
+// STATS впереди - в ней есть строки с id >= nextID (например, сохранённые вне очереди до сбоя),
+// позади - в PROD есть строки после max ID в STATS, которые по токену уже должны быть сохранены.
+func reconcileResume(ctx context.Context, retry retryPolicy, prodDB, statsDB Database, nextID uint64, policy ResumeVerifyPolicy) (uint64, error) {
+	statsMaxID, err := withRetry(ctx, retry, func() (uint64, error) {
+		return statsDB.GetMaxID(ctx)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get STATS max ID: %w", err)
+	}
+
+	diverged := statsMaxID >= nextID
+	if !diverged && statsMaxID+1 < nextID {
+		missing, err := withRetry(ctx, retry, func() ([]Row, error) {
+			return prodDB.LoadRows(ctx, statsMaxID+1, nextID)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("load PROD rows: %w", err)
+		}
+		diverged = len(missing) > 0
+	}
+	if !diverged {
+		return nextID, nil
+	}
+
+	switch policy {
+	case ResumeTrustStats:
+		return min(statsMaxID, nextID), nil
+	case ResumeTrustCheckpoint:
+		return nextID, nil
+	default:
+		return 0, fmt.Errorf("STATS max ID %d, token next ID %d: %w", statsMaxID, nextID, ErrCheckpointDivergence)
+	}
+}
+
+// epochMetaKey - служебный ключ STATS с номером эпохи
+const epochMetaKey = "copy_epoch"
+
+// syncEpoch читает эпоху STATS, а при bump (полная переливка) начинает новую
+func syncEpoch(ctx context.Context, retry retryPolicy, statsDB Database, bump bool) (uint64, error) {
+	value, err := withRetry(ctx, retry, func() (string, error) {
+		return statsDB.GetMeta(ctx, epochMetaKey)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("get STATS epoch: %w", err)
+	}
+
+	var epoch uint64
+	if value != "" {
+		epoch, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse STATS epoch %q: %w", value, err)
+		}
+	}
+	if !bump {
+		return epoch, nil
+	}
+
+	epoch++
+	_, err = withRetry(ctx, retry, func() (struct{}, error) {
+		return struct{}{}, statsDB.SetMeta(ctx, epochMetaKey, strconv.FormatUint(epoch, 10))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("set STATS epoch: %w", err)
+	}
+	return epoch, nil
+}
+
+// commitWatermark отслеживает границу, до которой все батчи сохранены.
+// Воркеры сохраняют батчи вперемешку, поэтому граница сдвигается только
+// по непрерывной последовательности завершённых батчей.
+type commitWatermark struct {
+	state resumeState // NextID меняется под mu, остальное неизменно
+
+	mu      sync.Mutex
+	nextSeq uint64
+	pending map[uint64]uint64 // seq завершённого батча -> первый id после него
+
+	// батчи, отправленные воркерам и ещё не сохранённые, для ResumeToken.Missing
+	sent      map[uint64][]uint64 // seq -> id строк батча
+	loadedTo  uint64              // первый id после последнего отправленного батча
+	untracked bool                // id строк не извлекаются (rowID), Missing в токен не попадает
+}
+
+func newCommitWatermark(state resumeState) *commitWatermark {
+	return &commitWatermark{
+		state:    state,
+		pending:  map[uint64]uint64{},
+		sent:     map[uint64][]uint64{},
+		loadedTo: state.NextID,
+	}
+}
+
+// send отмечает батч seq со строками ids, покрывающий id вплоть до nextID, отправленным воркерам.
+// ids == nil - id строк неизвестны, тогда при возобновлении диапазон сканируется целиком
+func (w *commitWatermark) send(seq uint64, ids []uint64, nextID uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if ids == nil {
+		w.untracked = true
+	}
+	w.sent[seq] = ids
+	w.loadedTo = max(w.loadedTo, nextID)
+}
+
+// commit отмечает батч seq, покрывающий id вплоть до nextID (не включительно), сохранённым
+func (w *commitWatermark) commit(seq, nextID uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.sent, seq)
+	w.loadedTo = max(w.loadedTo, nextID)
+	w.pending[seq] = nextID
+	for {
+		next, ok := w.pending[w.nextSeq]
+		if !ok {
+			return
+		}
+		delete(w.pending, w.nextSeq)
+		w.state.NextID = next
+		w.nextSeq++
+	}
+}
+
+// nextID - первый id, который ещё не гарантированно сохранён
+func (w *commitWatermark) nextID() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.state.NextID
+}
+
+func (w *commitWatermark) token() ResumeToken {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	st := w.state
+	if w.loadedTo > st.NextID && !w.untracked {
+		var ids []uint64
+		for _, batch := range w.sent {
+			for _, id := range batch {
+				// инкрементальная переливка перезаписывает строку NextID-1, она уже в STATS
+				if id >= st.NextID {
+					ids = append(ids, id)
+				}
+			}
+		}
+		slices.Sort(ids)
+		st.Missing, st.LoadedTo = compactIDs(ids), w.loadedTo
+	}
+	return encodeResumeToken(st)
+}
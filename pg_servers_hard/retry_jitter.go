@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// JitterStrategy - способ "размазывания" пауз между повторами.
+// При большом кол-ве конкурентных воркеров от стратегии зависит, насколько равномерно
+// повторы распределятся во времени и не ударят ли по базе одновременно.
+type JitterStrategy int
+
+const (
+	// JitterNone - без джиттера, чистый экспоненциальный backoff
+	JitterNone JitterStrategy = iota
+	// JitterFull - backoff + случайное [0, backoff), поведение по умолчанию
+	JitterFull
+	// JitterEqual - половина backoff фиксирована, вторая половина случайна: [backoff/2, backoff)
+	JitterEqual
+	// JitterDecorrelated - пауза растёт от предыдущей паузы, а не от номера попытки:
+	// случайное [base, prev*3)
+	JitterDecorrelated
+)
+
+// nextDelay возвращает паузу перед очередным повтором.
+// backoff - экспоненциально растущая база, prev - предыдущая пауза (0 перед первым повтором).
+func (p retryPolicy) nextDelay(backoff, prev time.Duration) time.Duration {
+	switch p.jitter {
+	case JitterNone:
+		return backoff
+	case JitterEqual:
+		half := backoff / 2
+		return half + p.rand.duration(backoff-half)
+	case JitterDecorrelated:
+		prev = max(prev, p.backoff)
+		return p.backoff + p.rand.duration(prev*3-p.backoff)
+	default:
+		return backoff + p.rand.duration(backoff)
+	}
+}
+
+// lockedRand - источник случайности для джиттера; *rand.Rand не потокобезопасен,
+// а политика повторов общая на всех воркеров. nil - глобальный источник math/rand.
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// duration возвращает случайную длительность из [0, n)
+func (r *lockedRand) duration(n time.Duration) time.Duration {
+	// rand.Int63n паникует на нуле, а нулевая пауза допустима в политике
+	if n <= 0 {
+		return 0
+	}
+	if r == nil {
+		return time.Duration(rand.Int63n(int64(n)))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rnd.Int63n(int64(n)))
+}
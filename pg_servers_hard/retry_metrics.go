@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RetryMetrics - сводка повторов операций с базами за переливку: насколько "флапали" базы
+type RetryMetrics struct {
+	Attempts            uint64        // всего вызовов операций, включая первые попытки
+	SuccessesAfterRetry uint64        // операций, успешных не с первой попытки
+	Exhaustions         uint64        // операций, исчерпавших повторы или общий бюджет повторов
+	BackoffTotal        time.Duration // суммарное время пауз между повторами по всем воркерам
+}
+
+// retryMetrics - счётчики для RetryMetrics, обновляются конкурентно из всех стадий
+type retryMetrics struct {
+	attempts            atomic.Uint64
+	successesAfterRetry atomic.Uint64
+	exhaustions         atomic.Uint64
+	backoffTotal        atomic.Int64
+}
+
+func (m *retryMetrics) attempt() {
+	if m != nil {
+		m.attempts.Add(1)
+	}
+}
+
+func (m *retryMetrics) success(attempt int) {
+	if m != nil && attempt > 0 {
+		m.successesAfterRetry.Add(1)
+	}
+}
+
+func (m *retryMetrics) exhausted() {
+	if m != nil {
+		m.exhaustions.Add(1)
+	}
+}
+
+func (m *retryMetrics) slept(d time.Duration) {
+	if m != nil {
+		m.backoffTotal.Add(int64(d))
+	}
+}
+
+func (m *retryMetrics) snapshot() RetryMetrics {
+	return RetryMetrics{
+		Attempts:            m.attempts.Load(),
+		SuccessesAfterRetry: m.successesAfterRetry.Load(),
+		Exhaustions:         m.exhaustions.Load(),
+		BackoffTotal:        time.Duration(m.backoffTotal.Load()),
+	}
+}
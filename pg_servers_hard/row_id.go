@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+// RowIDer - значение первой колонки, умеющее вернуть id строки.
+// Нужен для опций, которым важны id загруженных строк (например, WithGapReporter),
+// если первая колонка не целое число.
+type RowIDer interface {
+	ID() uint64
+}
+
+// rowID извлекает id строки из первой колонки: uint64, неотрицательный int64 или RowIDer
+func rowID(r Row) (uint64, error) {
+	if len(r) == 0 {
+		return 0, fmt.Errorf("row id: empty row")
+	}
+
+	switch v := r[0].(type) {
+	case uint64:
+		return v, nil
+	case int64:
+		if v >= 0 {
+			return uint64(v), nil
+		}
+	case RowIDer:
+		return v.ID(), nil
+	}
+
+	return 0, fmt.Errorf("row id: unsupported first column %T", r[0])
+}
+
+// projectColumns оставляет в строках колонку id (0) и колонки keep в заданном порядке.
+// Исходные строки не меняются: они могут разделяться с PROD.
+func projectColumns(rows []Row, keep []int) ([]Row, error) {
+	projected := make([]Row, len(rows))
+	for i, r := range rows {
+		p := make(Row, 1, len(keep)+1)
+		p[0] = r[0]
+		for _, col := range keep {
+			if col < 0 || col >= len(r) {
+				return nil, fmt.Errorf("keep column %d: out of range for row with %d columns", col, len(r))
+			}
+			if col == 0 {
+				continue
+			}
+			p = append(p, r[col])
+		}
+		projected[i] = p
+	}
+	return projected, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+)
+
+// Сериализация батчей для передачи между процессами (читатель PROD и писатель STATS
+// в разных сервисах). Row - это []interface{}, поэтому gob должен заранее знать
+// все конкретные типы, которые могут лежать в колонках, см. RegisterRowType.
+
+// RegisterRowType регистрирует конкретный тип значения колонки для EncodeRows/DecodeRows.
+// Базовые типы (числа, строки, []byte) регистрировать не нужно.
+func RegisterRowType(v any) {
+	gob.Register(v)
+}
+
+// EncodeRows сериализует строки в gob
+func EncodeRows(rows []Row) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rows); err != nil {
+		return nil, fmt.Errorf("encode rows: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeRows восстанавливает строки, сериализованные EncodeRows
+func DecodeRows(data []byte) ([]Row, error) {
+	var rows []Row
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode rows: %w", err)
+	}
+	return rows, nil
+}
+
+// batchChecksum считает CRC32 над сериализованным видом батча.
+// Для стабильной суммы значения колонок должны кодироваться детерминированно (например, без map).
+func batchChecksum(rows []Row) (uint32, error) {
+	data, err := EncodeRows(rows)
+	if err != nil {
+		return 0, fmt.Errorf("batch checksum: %w", err)
+	}
+	return crc32.ChecksumIEEE(data), nil
+}
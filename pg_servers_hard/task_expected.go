@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -19,11 +23,26 @@ type Database interface {
 	// Возвращает максимальный id в таблице
 	GetMaxID(ctx context.Context) (uint64, error)
 
+	// Возвращает минимальный id в таблице, 0 - таблица пуста
+	GetMinID(ctx context.Context) (uint64, error)
+
 	// Загружает строки из диапазона [minID, maxID)
 	LoadRows(ctx context.Context, minID, maxID uint64) ([]Row, error)
 
+	// Загружает строки с перечисленными id в порядке возрастания id, отсутствующие id пропускаются
+	LoadRowsByIDs(ctx context.Context, ids []uint64) ([]Row, error)
+
 	// Сохраняет строки, вызов идемпотентен
 	SaveRows(ctx context.Context, rows []Row) error
+
+	// Проверяет доступность базы (подключение и права доступа)
+	Ping(ctx context.Context) error
+
+	// Возвращает значение служебного ключа таблицы, "" - ключ не задан
+	GetMeta(ctx context.Context, key string) (string, error)
+
+	// Сохраняет значение служебного ключа таблицы
+	SetMeta(ctx context.Context, key, value string) error
 }
 
 // Также внутри пакета дана функция подключения:
@@ -33,6 +52,33 @@ type Database interface {
 // временные ошибки обернуты кастомной ошибкой ErrDBTemporal
 var ErrDBTemporal = errors.New("temporary db error")
 
+// ErrSourceRewound - при возобновлении максимальный id в PROD оказался меньше, чем в STATS
+// (например, PROD был восстановлен из бэкапа), продолжать переливку в таком случае нельзя
+var ErrSourceRewound = errors.New("source max ID is behind target")
+
+// ErrRetryBudgetExhausted - исчерпан общий на переливку бюджет повторов (WithRetryBudget)
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// ErrQuotaExceeded - переливка остановлена, т.к. достигнут лимит строк (WithMaxRows)
+var ErrQuotaExceeded = errors.New("copy quota exceeded")
+
+// ErrBatchCorrupted - контрольная сумма батча перед сохранением не совпала с посчитанной
+// при загрузке (WithBatchChecksum)
+var ErrBatchCorrupted = errors.New("batch checksum mismatch")
+
+// ErrSourceEmpty - полная переливка не нашла в PROD ни одной строки (WithRequireNonEmpty)
+var ErrSourceEmpty = errors.New("source table is empty")
+
+// ErrDeadlineReached - переливка остановлена до конца диапазона, т.к. подошёл срок WithDeadline.
+// Уже взятые батчи сохранены, продолжить можно по CopyResult.ResumeToken.
+var ErrDeadlineReached = errors.New("copy deadline reached")
+
+// ErrSourceMismatch - STATS уже заполнялась из другого источника (WithExpectedSourceID)
+var ErrSourceMismatch = errors.New("target was populated from a different source")
+
+// ErrWriteNotConfirmed - после SaveRows часть строк не нашлась в STATS (WithReadAfterWrite)
+var ErrWriteNotConfirmed = errors.New("write not confirmed")
+
 // Проанализировав требования, приходим к выводу, что нам потребуется
 // определить какой-то размер батча, кол-во воркеров, а также какую-то политику повторов.
 // Для чего заведём константы; вслух можно сказать, что по-хорошему храним это где-нибудь в конфиге,
@@ -46,44 +92,267 @@ const backoffBaseForRetries = time.Millisecond * 100
 // CopyTable копирует таблицу profiles с одного сервера на другой.
 // Если full=false, то переливка продолжается с места прошлой ошибки.
 // Если full=true, то переливка выполняется "с нуля".
-func CopyTable(fromName string, toName string, full bool) error {
-	ctx, cancel := context.WithCancel(context.Background())
+func CopyTable(fromName string, toName string, full bool, opts ...CopyOption) error {
+	return newCopyController().run(fromName, toName, full, newCopyConfig(opts))
+}
+
+// run - тело CopyTable; через хэндл c стадии можно приостанавливать извне (см. StartCopy),
+// в нём же копится статистика переливки
+func (c *CopyController) run(fromName string, toName string, full bool, cfg *copyConfig) (err error) {
+	ctx, cancel := context.WithCancel(cfg.ctx)
 	defer cancel()
+	defer c.closeProgress()
+
+	started := time.Now()
+
+	// WithStartDelay отсчитывается от запуска, а не от готовности подключений
+	startAt := cfg.startAt
+	if cfg.startDelay > 0 {
+		startAt = cfg.clock.Now().Add(cfg.startDelay)
+	}
+	cfg.logf("copy started: %s -> %s, mode=%s", fromName, strings.Join(cfg.targets(toName), "+"), copyMode(full))
+	defer func() {
+		// при ошибке сохраняем позицию, до которой всё гарантированно перелито
+		if w := c.watermark.Load(); err != nil && w != nil && cfg.checkpointOnError != nil {
+			if cerr := cfg.checkpointOnError(w.token()); cerr != nil {
+				err = errors.Join(err, fmt.Errorf("checkpoint on error: %w", cerr))
+			}
+		}
 
-	// подключение с ретраями
-	prodDB, err := withRetry(ctx, func() (Database, error) {
+		c.logSummary(cfg, full, time.Since(started), err)
+		if cfg.onComplete != nil {
+			cfg.onComplete(c.Result(), err)
+		}
+	}()
+
+	retry := defaultRetryPolicy()
+	retry.retries = &c.retries
+	retry.metrics = &c.retryMetrics
+	if cfg.retryBudget > 0 {
+		retry.budget = newRetryBudget(cfg.retryBudget)
+	}
+	retry.retryable = cfg.retryable
+	if cfg.jitter != nil {
+		retry.jitter = *cfg.jitter
+		retry.rand = cfg.jitterRand
+	}
+
+	// блокировка целевой таблицы берётся до подключений, чтобы вторая переливка отказывала сразу
+	if cfg.locker != nil {
+		for _, name := range cfg.targets(toName) {
+			release, err := cfg.locker.Acquire(ctx, lockKey(name))
+			if err != nil {
+				return fmt.Errorf("lock %s: %w", lockKey(name), err)
+			}
+			defer release()
+		}
+	}
+
+	connectRetry := retry
+	if cfg.connectAttempts > 0 {
+		connectRetry.maxRetries = cfg.connectAttempts - 1
+		connectRetry.backoff = cfg.connectBackoff
+	}
+
+	connectProd := func(ctx context.Context) (Database, error) {
 		return Connect(ctx, fromName)
+	}
+	connectStats := func(ctx context.Context) (Database, error) {
+		return connectTarget(ctx, toName, cfg)
+	}
+
+	// dial подключается с ретраями, а при WithReconnectOnTemporalError оборачивает подключение
+	// так, чтобы сломанное заменялось новым тем же способом
+	dial := func(ctx context.Context, connect func(context.Context) (Database, error)) (Database, error) {
+		redial := func(ctx context.Context) (Database, error) {
+			return withRetry(ctx, connectRetry, func() (Database, error) {
+				return connect(ctx)
+			})
+		}
+
+		db, err := redial(ctx)
+		if err != nil || cfg.isConnFatal == nil {
+			return db, err
+		}
+		return newReconnectingDB(db, redial, cfg.isConnFatal), nil
+	}
+
+	// подключаемся к обеим базам параллельно, каждое подключение со своими ретраями:
+	// время старта - максимум из двух подключений, а не их сумма
+	var prodDB, statsDB Database
+	cg, cctx := errgroup.WithContext(ctx)
+	cg.Go(func() error {
+		db, err := dial(cctx, connectProd)
+		if err != nil {
+			return fmt.Errorf("connect to PROD: %w", err)
+		}
+		prodDB = db
+		return nil
+	})
+	cg.Go(func() error {
+		db, err := dial(cctx, connectStats)
+		if err != nil {
+			return fmt.Errorf("connect to STATS: %w", err)
+		}
+		statsDB = db
+		return nil
+	})
+	err = cg.Wait()
+
+	// если одно из подключений не удалось, успевшее открыться второе всё равно закрываем
+	if prodDB != nil {
+		defer prodDB.Close()
+	}
+	if statsDB != nil {
+		defer statsDB.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	// пул подключений к STATS (WithConnections): если база выполняет запросы в рамках
+	// подключения по очереди, то воркеры на одном подключении фактически пишут последовательно
+	statsPool := []Database{statsDB}
+	for len(statsPool) < cfg.statsConnections {
+		conn, err := dial(ctx, connectStats)
+		if err != nil {
+			return fmt.Errorf("connect to STATS: %w", err)
+		}
+		defer conn.Close()
+
+		statsPool = append(statsPool, conn)
+	}
+
+	if cfg.preflight {
+		if err := prodDB.Ping(ctx); err != nil {
+			return fmt.Errorf("preflight PROD: %w", err)
+		}
+		if err := statsDB.Ping(ctx); err != nil {
+			return fmt.Errorf("preflight STATS: %w", err)
+		}
+	}
+
+	if cfg.expectedSourceID != "" {
+		if err := checkSourceID(ctx, retry, statsDB, cfg.expectedSourceID); err != nil {
+			return err
+		}
+	}
+
+	// отложенный старт (WithStartAt/WithStartDelay): подключения уже готовы,
+	// чтение и запись начнутся в назначенное время
+	if err := waitScheduledStart(ctx, cfg, startAt); err != nil {
+		return err
+	}
+
+	endID, err := withRetry(ctx, retry, func() (uint64, error) {
+		return prodDB.GetMaxID(ctx)
 	})
 	if err != nil {
-		return fmt.Errorf("connect to PROD: %w", err)
+		return fmt.Errorf("get PROD max ID: %w", err)
 	}
-	defer prodDB.Close()
 
-	statsDB, err := withRetry(ctx, func() (Database, error) {
-		return Connect(ctx, toName)
+	// минимальный id - отпечаток источника для ResumeToken
+	minID, err := withRetry(ctx, retry, func() (uint64, error) {
+		return prodDB.GetMinID(ctx)
 	})
 	if err != nil {
-		return fmt.Errorf("connect to STATS: %w", err)
+		return fmt.Errorf("get PROD min ID: %w", err)
+	}
+
+	// эпоха STATS (WithReplayProtection): полная переливка начинает новую эпоху,
+	// и токены, выданные до неё, больше не принимаются
+	var epoch uint64
+	if cfg.replayProtection {
+		epoch, err = syncEpoch(ctx, retry, statsDB, full)
+		if err != nil {
+			return err
+		}
 	}
-	defer statsDB.Close()
 
 	var startID uint64
-	if full {
+	// startID взят из max ID в STATS (инкрементальная переливка)
+	fromStats := false
+	// строки, не подтверждённые сохранением в прошлом запуске (ResumeToken.Missing):
+	// сборщик дочитывает их по id, а сканирование диапазона начинает с resumeTo
+	var resumeIDs []uint64
+	var resumeTo uint64
+	switch {
+	case full:
 		startID = 0
-	} else {
-		startID, err = withRetry(ctx, func() (uint64, error) {
+	case cfg.forceStartID != nil:
+		// ручное восстановление: позиция задана оператором, состояние STATS не учитываем
+		startID = *cfg.forceStartID
+		if startID > endID {
+			return fmt.Errorf("forced start ID %d is beyond PROD max ID %d", startID, endID)
+		}
+	case cfg.resumeToken != "":
+		// позиция из токена прошлого запуска вместо max ID в STATS
+		st, err := decodeResumeToken(cfg.resumeToken)
+		if err != nil {
+			return err
+		}
+		if err := st.validate(fromName, minID, endID); err != nil {
+			return err
+		}
+		if cfg.replayProtection && st.Epoch != epoch {
+			return fmt.Errorf("token epoch %d, STATS epoch %d: %w", st.Epoch, epoch, ErrStaleCheckpoint)
+		}
+		startID = st.NextID
+		if cfg.resumeVerify != nil {
+			startID, err = reconcileResume(ctx, retry, prodDB, statsDB, st.NextID, *cfg.resumeVerify)
+			if err != nil {
+				return err
+			}
+		}
+		if startID == st.NextID && st.LoadedTo > st.NextID {
+			resumeIDs, resumeTo = expandIDs(st.Missing), st.LoadedTo
+		}
+	default:
+		startID, err = withRetry(ctx, retry, func() (uint64, error) {
 			return statsDB.GetMaxID(ctx)
 		})
 		if err != nil {
 			return fmt.Errorf("get STATS max ID: %w", err)
 		}
+
+		// без этой проверки цикл ниже просто ничего не сделает и аномалия останется незамеченной
+		if endID < startID {
+			return fmt.Errorf("PROD max ID %d, STATS max ID %d: %w", endID, startID, ErrSourceRewound)
+		}
+		fromStats = true
 	}
 
-	endID, err := withRetry(ctx, func() (uint64, error) {
-		return prodDB.GetMaxID(ctx)
+	// первый id, который ещё не гарантированно сохранён: при инкрементальной переливке
+	// строка startID уже есть в STATS и лишь перезаписывается повторно
+	watermarkStart := startID
+	if fromStats && startID > 0 {
+		watermarkStart++
+	}
+
+	cfg.logf("copy range: [%d, %d]", startID, endID)
+	c.endID.Store(endID)
+	c.expectedRows.Store(cfg.expectedRows)
+
+	watermark := newCommitWatermark(resumeState{
+		Table:       tableName,
+		Source:      fromName,
+		NextID:      watermarkStart,
+		SourceMinID: minID,
+		Epoch:       epoch,
 	})
-	if err != nil {
-		return fmt.Errorf("get PROD max ID: %w", err)
+	c.watermark.Store(watermark)
+
+	if cfg.statsInterval > 0 && cfg.statsLogger != nil {
+		stop := make(chan struct{})
+		reported := make(chan struct{})
+		go func() {
+			defer close(reported)
+			c.reportStats(cfg, watermark, stop)
+		}()
+		defer func() {
+			close(stop)
+			<-reported
+		}()
 	}
 
 	// Создадим канал, в который будут передаваться батчи, собранные из рез-тов LoadRows()
@@ -109,93 +378,663 @@ func CopyTable(fromName string, toName string, full bool) error {
 	// Небуф. канал как раз даёт больший контроль в том смысле, что меньше нужно заботиться
 	// об утечке данных и горутин. Отчего, небуф. канал является предпочтильнее, если нет
 	// основательных причин использовать буфер.
-	rowsCh := make(chan []Row)
+	//
+	// Такой причиной может быть медленная запись: тогда WithReadahead включает предзагрузку
+	// заданного кол-ва батчей, пока воркеры заняты сохранением.
+	rowsCh := make(chan copyBatch, cfg.readahead)
+
+	// жёсткий лимит батчей в памяти (WithMaxInFlightBatches) независимо от буфера rowsCh
+	inFlight := newSemaphore(cfg.maxInFlightBatches)
+
+	// лимит одновременных LoadRows (WithMaxOpenRanges)
+	openRanges := newSemaphore(cfg.maxOpenRanges)
+
+	// сборщик остановился по сроку WithDeadline, читается после g.Wait
+	deadlineReached := false
 
 	g, gctx := errgroup.WithContext(ctx)
 
 	// Горутина собирает батчи из PROD
-	g.Go(func() error {
+	g.Go(labeled(gctx, pprof.Labels("role", "collector"), func(gctx context.Context) error {
 		defer close(rowsCh)
 
+		// размер батча фиксированный, либо подбирается под объём строк (WithTargetBatchBytes)
+		limit := batchSize
+		var sizer *batchSizer
+		if cfg.targetBatchBytes > 0 {
+			sizer = newBatchSizer(cfg)
+			limit = sizer.limit()
+		}
+
+		// встреченные id для WithDetectDuplicates
+		var seenIDs idSet
+		if cfg.detectDuplicates {
+			seenIDs = newIDSet(startID, endID)
+		}
+
+		// id последней загруженной строки для WithGapReporter
+		var lastID uint64
+		seenRow := false
+
+		// checkRows проверяет загруженные строки на повторы (WithDetectDuplicates)
+		// и пропуски id (WithGapReporter)
+		checkRows := func(rows []Row) error {
+			if seenIDs != nil {
+				for _, r := range rows {
+					id, err := rowID(r)
+					if err != nil {
+						return fmt.Errorf("detect duplicates: %w", err)
+					}
+					if seenIDs.add(id) {
+						return fmt.Errorf("row %d: %w", id, ErrDuplicateID)
+					}
+				}
+			}
+
+			if cfg.gapReporter != nil {
+				for _, r := range rows {
+					id, err := rowID(r)
+					if err != nil {
+						return fmt.Errorf("gap reporter: %w", err)
+					}
+					if seenRow && id > lastID && id-lastID-1 > cfg.gapThreshold {
+						cfg.gapReporter(lastID+1, id-1)
+					}
+					lastID, seenRow = id, true
+				}
+			}
+			return nil
+		}
+
+		// send отдаёт батч seq, покрывающий id до nextID, воркерам
+		send := func(seq uint64, batchRows []Row, nextID uint64) error {
+			// в пустом диапазоне сохранять нечего, он сразу считается перенесённым
+			if len(batchRows) == 0 {
+				watermark.commit(seq, nextID)
+				inFlight.release()
+				return nil
+			}
+
+			// id строк нужны токену, если батч не успеет сохраниться
+			ids := make([]uint64, len(batchRows))
+			for i, r := range batchRows {
+				id, err := rowID(r)
+				if err != nil {
+					ids = nil
+					break
+				}
+				ids[i] = id
+			}
+			watermark.send(seq, ids, nextID)
+
+			batch := copyBatch{rows: batchRows, seq: seq, nextID: nextID}
+			if cfg.batchChecksum {
+				sum, err := batchChecksum(batchRows)
+				if err != nil {
+					return err
+				}
+				batch.checksum = sum
+			}
+
+			select {
+			case <-gctx.Done():
+				return gctx.Err()
+			case rowsCh <- batch:
+			}
+			return nil
+		}
+
+		// сначала строки, которые прошлый запуск загрузил, но не сохранил: читаем ровно их,
+		// а не весь диапазон до resumeTo, остальные id в нём уже в STATS или отсутствуют в PROD
 		curID := startID
-		for curID < endID {
-			batchRows := make([]Row, 0, batchSize)
+		seq := uint64(0)
+		for ; len(resumeIDs) > 0; seq++ {
+			// к сроку WithDeadline не дочитанные строки останутся за позицией токена
+			if cfg.deadlineNear() {
+				deadlineReached = true
+				return nil
+			}
+
+			if err := c.gate.wait(gctx); err != nil {
+				return err
+			}
+			if err := inFlight.acquire(gctx); err != nil {
+				return err
+			}
+
+			ids := resumeIDs[:min(limit, len(resumeIDs))]
+			resumeIDs = resumeIDs[len(ids):]
+			nextID := resumeTo
+			if len(resumeIDs) > 0 {
+				nextID = resumeIDs[0]
+			}
+
+			if err := openRanges.acquire(gctx); err != nil {
+				return err
+			}
+			rows, err := withRetry(gctx, retry, func() ([]Row, error) {
+				return prodDB.LoadRowsByIDs(gctx, ids)
+			})
+			openRanges.release()
+			if err != nil {
+				return fmt.Errorf("load rows by IDs: %w", err)
+			}
+			if err := checkRows(rows); err != nil {
+				return err
+			}
+
+			if err := send(seq, rows, nextID); err != nil {
+				return err
+			}
+			curID = nextID
+		}
+		curID = max(curID, resumeTo)
 
-			for len(batchRows) < batchSize && curID < endID {
-				nextID := curID + uint64(batchSize-len(batchRows))
+		// диапазон [startID, endID] включительно - endID это id последней строки в PROD
+		for ; curID <= endID; seq++ {
+			// к сроку WithDeadline новые батчи не берём, уже отправленные воркеры дописывают
+			if cfg.deadlineNear() {
+				deadlineReached = true
+				return nil
+			}
+
+			// слот под батч занимаем до загрузки, освобождает его воркер после сохранения
+			if err := inFlight.acquire(gctx); err != nil {
+				return err
+			}
 
-				rows, err := withRetry(gctx, func() ([]Row, error) {
-					return prodDB.LoadRows(gctx, curID, nextID)
-				})
+			batchRows := make([]Row, 0, batchCap(limit, cfg.expectedRows))
+
+			// Drain, вызванный между батчами, относится к уже отправленному батчу:
+			// не даём ему досрочно отрезать новый
+			select {
+			case <-c.drainCh:
+			default:
+			}
+
+			// набираем батч, пока он не заполнится, не закончится диапазон, не попросят Drain
+			// или не выйдет время на набор (WithBatchTimeoutFlush)
+			drained := false
+			batchStarted := cfg.clock.Now()
+			for len(batchRows) < limit && curID <= endID && !drained {
+				// на паузе новые LoadRows не выдаём, позиция curID при этом сохраняется
+				if err := c.gate.wait(gctx); err != nil {
+					return err
+				}
+
+				// не выходим за endID: хвостовой батч отправляется сразу, без сканирования пустоты
+				nextID := min(curID+uint64(limit-len(batchRows)), endID+1)
+
+				rows, err := loadRange(gctx, retry, prodDB, openRanges, curID, nextID, cfg.loadConcurrency)
 				if err != nil {
 					return fmt.Errorf("load rows: %w", err)
 				}
 
+				if err := checkRows(rows); err != nil {
+					return err
+				}
+
 				batchRows = append(batchRows, rows...)
 				curID = nextID
-			}
 
-			if len(batchRows) > 0 {
+				if sizer != nil {
+					sizer.observe(rows)
+					limit = sizer.limit()
+				}
+
 				select {
-				case <-gctx.Done():
-					return gctx.Err()
-				case rowsCh <- batchRows:
+				case <-c.drainCh:
+					drained = true
+				default:
+				}
+
+				// на разреженных id батч может набираться долго, отдаём воркерам то, что уже есть
+				if cfg.batchFlushTimeout > 0 && len(batchRows) > 0 && cfg.clock.Now().Sub(batchStarted) >= cfg.batchFlushTimeout {
+					drained = true
 				}
+				// к сроку отдаём недобранный батч, чтобы он успел сохраниться
+				if cfg.deadlineNear() {
+					drained = true
+				}
+			}
+
+			if err := send(seq, batchRows, curID); err != nil {
+				return err
 			}
 		}
 		return nil
-	})
+	}))
 
-	// Воркеры сохраняют данные
-	for range workers {
-		g.Go(func() error {
+	// Воркеры сохраняют данные, подключения пула распределяются между ними по кругу
+	//
+	// С WithSortedSave воркер один: сборщик отдаёт батчи по возрастанию id, так что
+	// и SaveRows получают строки в глобальном порядке возрастания
+	saveWorkers := workers
+	if cfg.sortedSave {
+		saveWorkers = 1
+	}
+	for i := range saveWorkers {
+		statsDB := statsPool[i%len(statsPool)]
+
+		g.Go(labeled(gctx, pprof.Labels("role", "save-worker", "id", strconv.Itoa(i)), func(gctx context.Context) error {
 			for {
+				// на паузе не забираем новые батчи, уже взятый в работу батч дописывается
+				if err := c.gate.wait(gctx); err != nil {
+					return err
+				}
+
 				select {
 				case <-gctx.Done():
 					return gctx.Err()
-				case rows, ok := <-rowsCh:
+				case batch, ok := <-rowsCh:
 					if !ok {
 						return nil
 					}
-					_, err := withRetry(gctx, func() ([]Row, error) {
-						return nil, statsDB.SaveRows(gctx, rows)
+					rows := batch.rows
+
+					if cfg.transformBatch != nil {
+						cfg.transformBatch(rows)
+					}
+
+					// сверяем контрольную сумму до сохранения, чтобы испорченный в пути батч не попал в STATS
+					if cfg.batchChecksum {
+						sum, err := batchChecksum(rows)
+						if err != nil {
+							return err
+						}
+						if sum != batch.checksum {
+							return fmt.Errorf("save rows: %w", ErrBatchCorrupted)
+						}
+					}
+
+					if cfg.keepColumns != nil {
+						projected, err := projectColumns(rows, cfg.keepColumns)
+						if err != nil {
+							return fmt.Errorf("save rows: %w", err)
+						}
+						rows = projected
+					}
+
+					// резервируем строки в общем на всех воркеров счётчике квоты,
+					// батч, упёршийся в лимит, сохраняем частично
+					var quotaErr error
+					if cfg.maxRows > 0 {
+						reserved := c.reservedRows.Add(uint64(len(rows)))
+						if reserved > cfg.maxRows {
+							over := reserved - cfg.maxRows
+							if over >= uint64(len(rows)) {
+								return ErrQuotaExceeded
+							}
+							rows = rows[:uint64(len(rows))-over]
+							quotaErr = ErrQuotaExceeded
+						}
+					}
+
+					_, err := withRetry(gctx, retry, func() ([]Row, error) {
+						return nil, saveWithWatchdog(gctx, statsDB, rows, cfg.saveTimeout)
 					})
 					if err != nil {
 						return fmt.Errorf("save rows: %w", err)
 					}
+
+					if cfg.readAfterWrite {
+						if err := confirmWrite(gctx, retry, statsDB, rows); err != nil {
+							return fmt.Errorf("save rows: %w", err)
+						}
+					}
+
+					c.rowsCopied.Add(uint64(len(rows)))
+					c.batchesWritten.Add(1)
+					c.publishProgress()
+
+					// частично сохранённый батч в токен не попадает, при возобновлении он перельётся заново
+					if quotaErr != nil {
+						return quotaErr
+					}
+					watermark.commit(batch.seq, batch.nextID)
+					inFlight.release()
 				}
 			}
-		})
+		}))
 	}
 
 	// Синхронизируем завершение
 	if err := g.Wait(); err != nil {
 		return fmt.Errorf("copy failed: %w", err)
 	}
+
+	if deadlineReached {
+		return fmt.Errorf("copied %d rows, resume from ID %d: %w", c.rowsCopied.Load(), watermark.nextID(), ErrDeadlineReached)
+	}
+
+	if cfg.requireNonEmpty && full && endID == 0 && c.rowsCopied.Load() == 0 {
+		return ErrSourceEmpty
+	}
+
+	if copied := c.rowsCopied.Load(); rowsDiverge(copied, cfg.expectedRows) {
+		cfg.logf("copy warning: copied %d rows, expected about %d", copied, cfg.expectedRows)
+	}
 	return nil
 }
 
+// expectedRowsTolerance - допустимое расхождение факта с WithExpectedRows без предупреждения
+const expectedRowsTolerance = 0.1
+
+// rowsDiverge сообщает, что фактическое кол-во строк заметно отличается от ожидаемого
+func rowsDiverge(copied, expected uint64) bool {
+	if expected == 0 {
+		return false
+	}
+	diff := max(copied, expected) - min(copied, expected)
+	return float64(diff) > float64(expected)*expectedRowsTolerance
+}
+
+// batchCap - начальная ёмкость батча: при малом ожидаемом объёме не выделяем полный батч
+func batchCap(limit int, expected uint64) int {
+	if expected > 0 && expected < uint64(limit) {
+		return int(expected)
+	}
+	return limit
+}
+
+// sourceIDMetaKey - служебный ключ STATS с идентификатором источника данных
+const sourceIDMetaKey = "source_id"
+
+// checkSourceID сверяет идентификатор источника, записанный в STATS, с ожидаемым,
+// а если STATS ещё не заполнялась с проверкой - записывает его
+func checkSourceID(ctx context.Context, retry retryPolicy, statsDB Database, sourceID string) error {
+	recorded, err := withRetry(ctx, retry, func() (string, error) {
+		return statsDB.GetMeta(ctx, sourceIDMetaKey)
+	})
+	if err != nil {
+		return fmt.Errorf("get STATS source ID: %w", err)
+	}
+
+	switch recorded {
+	case sourceID:
+		return nil
+	case "":
+		_, err := withRetry(ctx, retry, func() (struct{}, error) {
+			return struct{}{}, statsDB.SetMeta(ctx, sourceIDMetaKey, sourceID)
+		})
+		if err != nil {
+			return fmt.Errorf("set STATS source ID: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("STATS source %q, copying from %q: %w", recorded, sourceID, ErrSourceMismatch)
+	}
+}
+
+// waitScheduledStart ждёт наступления времени старта startAt либо отмены контекста
+func waitScheduledStart(ctx context.Context, cfg *copyConfig, startAt time.Time) error {
+	if startAt.IsZero() {
+		return nil
+	}
+
+	d := startAt.Sub(cfg.clock.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	cfg.logf("copy scheduled at %s, waiting %s", startAt.Format(time.RFC3339), d)
+	select {
+	case <-cfg.clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for scheduled start: %w", ctx.Err())
+	}
+}
+
+// loadRange загружает строки из [minID, maxID). При parts > 1 диапазон делится на parts
+// равных поддиапазонов, которые загружаются параллельно, а результаты склеиваются по порядку,
+// так что строки остаются упорядочены по id.
+// Каждый LoadRows занимает слот open, паузы между повторами слот не держат.
+func loadRange(ctx context.Context, retry retryPolicy, db Database, open semaphore, minID, maxID uint64, parts int) ([]Row, error) {
+	load := func(ctx context.Context, from, to uint64) ([]Row, error) {
+		return withRetry(ctx, retry, func() ([]Row, error) {
+			if err := open.acquire(ctx); err != nil {
+				return nil, err
+			}
+			defer open.release()
+			return db.LoadRows(ctx, from, to)
+		})
+	}
+
+	if parts <= 1 || maxID-minID < uint64(parts) {
+		return load(ctx, minID, maxID)
+	}
+
+	step := (maxID - minID + uint64(parts) - 1) / uint64(parts)
+	results := make([][]Row, parts)
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i := range parts {
+		from := minID + uint64(i)*step
+		to := min(from+step, maxID)
+		if from >= to {
+			break
+		}
+
+		g.Go(func() error {
+			rows, err := load(gctx, from, to)
+			results[i] = rows
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return slices.Concat(results...), nil
+}
+
+// saveWithWatchdog вызывает SaveRows, а если он не завершился за timeout (зависший драйвер),
+// отменяет его контекст и возвращает временную ошибку для повтора, не дожидаясь возврата.
+// Брошенный вызов может всё же дописать батч позже: SaveRows идемпотентен, а статистика
+// считается только по успешно вернувшимся вызовам.
+func saveWithWatchdog(ctx context.Context, db Database, rows []Row, timeout time.Duration) error {
+	if timeout <= 0 {
+		return db.SaveRows(ctx, rows)
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.SaveRows(sctx, rows)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return fmt.Errorf("save rows exceeded %s: %w", timeout, ErrDBTemporal)
+	}
+}
+
+// confirmWrite перечитывает только что сохранённые строки из STATS по id
+// и проверяет, что ни одна не потерялась
+func confirmWrite(ctx context.Context, retry retryPolicy, db Database, rows []Row) error {
+	ids := make([]uint64, len(rows))
+	for i, r := range rows {
+		id, err := rowID(r)
+		if err != nil {
+			return fmt.Errorf("read after write: %w", err)
+		}
+		ids[i] = id
+	}
+
+	saved, err := withRetry(ctx, retry, func() ([]Row, error) {
+		return db.LoadRowsByIDs(ctx, ids)
+	})
+	if err != nil {
+		return fmt.Errorf("read after write: %w", err)
+	}
+
+	found := make(map[uint64]struct{}, len(saved))
+	for _, r := range saved {
+		id, err := rowID(r)
+		if err != nil {
+			return fmt.Errorf("read after write: %w", err)
+		}
+		found[id] = struct{}{}
+	}
+
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			return fmt.Errorf("row %d missing in target: %w", id, ErrWriteNotConfirmed)
+		}
+	}
+	return nil
+}
+
+// labeled оборачивает тело горутины стадии в pprof.Do, чтобы её стеки в CPU/block профилях
+// различались по меткам; метки доступны и в контексте, который получает fn
+func labeled(ctx context.Context, labels pprof.LabelSet, fn func(ctx context.Context) error) func() error {
+	return func() (err error) {
+		pprof.Do(ctx, labels, func(ctx context.Context) {
+			err = fn(ctx)
+		})
+		return err
+	}
+}
+
+// copyBatch - батч строк на пути от сборщика к воркерам
+type copyBatch struct {
+	rows     []Row
+	seq      uint64 // порядковый номер батча
+	nextID   uint64 // батч покрывает диапазон id до nextID (не включительно)
+	checksum uint32 // CRC32 сериализованных строк, считается только с WithBatchChecksum
+}
+
+// semaphore - счётный семафор (батчи в работе, открытые LoadRows), nil - без лимита
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// retryPolicy - политика повторов операций при временных ошибках
+type retryPolicy struct {
+	maxRetries int
+	backoff    time.Duration  // базовая пауза, растёт с кол-вом попыток
+	budget     *retryBudget   // общий на всю переливку лимит повторов, nil - без лимита
+	retries    *atomic.Uint64 // счётчик выполненных повторов для статистики, nil - не считаем
+	metrics    *retryMetrics  // сводка попыток и пауз для CopyResult, nil - не считаем
+	jitter     JitterStrategy
+	rand       *lockedRand // источник случайности для джиттера, nil - глобальный
+
+	// дополнительная классификация временных ошибок помимо ErrDBTemporal, nil - только ErrDBTemporal
+	retryable func(error) bool
+}
+
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxRetries: maxRetries,
+		backoff:    backoffBaseForRetries,
+		jitter:     JitterFull,
+	}
+}
+
+// isRetryable - стоит ли повторять операцию, завершившуюся ошибкой err
+func (p retryPolicy) isRetryable(err error) bool {
+	return errors.Is(err, ErrDBTemporal) || (p.retryable != nil && p.retryable(err))
+}
+
+// retryBudget - общий на все операции (и всех воркеров) лимит повторов.
+// Без него каждый вызов может повторяться maxRetries раз, и на большой переливке
+// флапающая база превращается в тысячи повторов без какого-либо общего потолка.
+type retryBudget struct {
+	left atomic.Int64
+}
+
+func newRetryBudget(n int) *retryBudget {
+	b := &retryBudget{}
+	b.left.Store(int64(n))
+	return b
+}
+
+// take списывает один повтор из бюджета, false - бюджет исчерпан
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	return b.left.Add(-1) >= 0
+}
+
 // Ф-я обертка для операций с ретраями при временных ошибках
-func withRetry[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+func withRetry[T any](ctx context.Context, policy retryPolicy, fn func() (T, error)) (T, error) {
 	var result T
 
-	// На основе базовой константы заводим переменную, которая будет расти с кол-вом попыток
-	backoff := backoffBaseForRetries
+	// На основе базовой паузы заводим переменную, которая будет расти с кол-вом попыток
+	backoff := policy.backoff
+	var sleep time.Duration
+
+	// Один таймер на все повторы вызова: при тысячах батчей с повторами
+	// таймер на каждую попытку даёт заметную нагрузку на аллокатор и GC
+	var t *time.Timer
+
+	var lastErr error
 
 	// + 1 т.к. первая попытка это не повтор
-	for range maxRetries + 1 {
+	for attempt := range policy.maxRetries + 1 {
+		policy.metrics.attempt()
 		val, err := fn()
 		if err == nil {
+			policy.metrics.success(attempt)
 			return val, nil
 		}
 		// Если ошибка не является постоянной, то есть смысл повторить
-		if errors.Is(err, ErrDBTemporal) {
-			// Добавляем джиттер
-			jitter := time.Duration(rand.Int63n(int64(backoff)))
-			sleep := backoff + jitter
+		if policy.isRetryable(err) {
+			lastErr = err
+			// После последней попытки ждать и тратить бюджет уже незачем
+			if attempt == policy.maxRetries {
+				break
+			}
+			if !policy.budget.take() {
+				policy.metrics.exhausted()
+				return result, fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, err)
+			}
+			if policy.retries != nil {
+				policy.retries.Add(1)
+			}
+
+			// Добавляем джиттер согласно стратегии политики
+			sleep = policy.nextDelay(backoff, sleep)
 
-			// Можно было использовать time.After, но *Timer даёт больше контроля, см. комменты ниже
-			t := time.NewTimer(sleep)
+			// Можно было использовать time.After, но *Timer даёт больше контроля, см. комменты ниже.
+			// Reset безопасен: к этому моменту значение из t.C уже вычитано (см. select ниже)
+			if t == nil {
+				t = time.NewTimer(sleep)
+			} else {
+				t.Reset(sleep)
+			}
 
 			// Помним, что у нас есть context и если он отменем, то нет смысла в след. попытках
 			select {
@@ -207,6 +1046,7 @@ func withRetry[T any](ctx context.Context, fn func() (T, error)) (T, error) {
 				}
 				return result, ctx.Err()
 			case <-t.C:
+				policy.metrics.slept(sleep)
 			}
 
 			backoff *= 2
@@ -215,5 +1055,8 @@ func withRetry[T any](ctx context.Context, fn func() (T, error)) (T, error) {
 		return result, err
 	}
 
-	return result, fmt.Errorf("too many retries: %w", ErrDBTemporal)
+	policy.metrics.exhausted()
+
+	// lastErr временных ошибок БД оборачивает ErrDBTemporal, так что errors.Is по нему продолжает работать
+	return result, fmt.Errorf("too many retries: %w", lastErr)
 }